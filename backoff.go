@@ -0,0 +1,148 @@
+package httpclient
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BackoffStrategy decides the backoff before the next retry after attempt failed with lastErr,
+// the attempts counts from 1, and no more retry if it returns false
+type BackoffStrategy interface {
+	NextBackoff(attempt int, lastErr error) (time.Duration, bool)
+}
+
+// BackoffSchedule is the backoff strategy sleeping the fixed durations in order, e.g. generated by FullJitterBackoff
+type BackoffSchedule []time.Duration
+
+// NextBackoff implements the BackoffStrategy interface
+func (s BackoffSchedule) NextBackoff(attempt int, lastErr error) (time.Duration, bool) {
+	if attempt < 1 || attempt > len(s) {
+		return 0, false
+	}
+	return s[attempt-1], true
+}
+
+var (
+	// jitterRand is the random source of the jittered backoff
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	// jitterMu guards jitterRand, which is not safe for concurrent use
+	jitterMu sync.Mutex
+)
+
+// randBetween returns a random duration in [min, max]
+func randBetween(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return min + time.Duration(jitterRand.Int63n(int64(max-min)+1))
+}
+
+// FullJitterBackoff returns the backoff of steps sleeps for SetRetry, each is random in [0, min(max, base*2^attempt)],
+// see the "Full Jitter" of https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func FullJitterBackoff(base, max time.Duration, steps int) []time.Duration {
+	backoff := make([]time.Duration, steps)
+
+	ceil := base
+	for i := range backoff {
+		if ceil > max {
+			ceil = max
+		}
+		backoff[i] = randBetween(0, ceil)
+		ceil *= 2
+	}
+	return backoff
+}
+
+// DecorrelatedJitterBackoff returns the backoff of steps sleeps for SetRetry, each is min(max, random in [base, prev*3]),
+// where prev starts from base, see the "Decorrelated Jitter" of https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func DecorrelatedJitterBackoff(base, max time.Duration, steps int) []time.Duration {
+	backoff := make([]time.Duration, steps)
+
+	sleep := base
+	for i := range backoff {
+		if sleep = randBetween(base, sleep*3); sleep > max {
+			sleep = max
+		}
+		backoff[i] = sleep
+	}
+	return backoff
+}
+
+// fullJitterStrategy is the backoff strategy of the full jitter drawn on each retry
+type fullJitterStrategy struct {
+	base, max time.Duration
+	steps     int
+}
+
+// FullJitterStrategy returns the backoff strategy retrying steps times, with the full jitter drawn on each retry,
+// so that the concurrent calls don't share the same schedule, see FullJitterBackoff
+func FullJitterStrategy(base, max time.Duration, steps int) BackoffStrategy {
+	return fullJitterStrategy{base: base, max: max, steps: steps}
+}
+
+// NextBackoff implements the BackoffStrategy interface
+func (s fullJitterStrategy) NextBackoff(attempt int, lastErr error) (time.Duration, bool) {
+	if attempt < 1 || attempt > s.steps {
+		return 0, false
+	}
+
+	ceil := s.base
+	for i := 1; i < attempt && ceil < s.max; i++ {
+		ceil *= 2
+	}
+	if ceil > s.max {
+		ceil = s.max
+	}
+	return randBetween(0, ceil), true
+}
+
+// retryAfterStrategy is the backoff strategy honoring the `Retry-After` header
+type retryAfterStrategy struct {
+	fallback BackoffStrategy
+}
+
+// RetryAfterStrategy returns the backoff strategy sleeping by the `Retry-After` header of the *HTTPError if present,
+// otherwise by the fallback strategy. The fallback strategy still decides when to stop.
+func RetryAfterStrategy(fallback BackoffStrategy) BackoffStrategy {
+	return retryAfterStrategy{fallback: fallback}
+}
+
+// NextBackoff implements the BackoffStrategy interface
+func (s retryAfterStrategy) NextBackoff(attempt int, lastErr error) (time.Duration, bool) {
+	d, ok := s.fallback.NextBackoff(attempt, lastErr)
+	if !ok {
+		return 0, false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(lastErr, &httpErr) {
+		if retryAfter, ok := parseRetryAfter(httpErr.Header.Get("Retry-After")); ok {
+			return retryAfter, true
+		}
+	}
+	return d, true
+}
+
+// parseRetryAfter parses the `Retry-After` header in seconds or HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,55 @@
+package httpclient
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchResult is the result of a GET request sent by BatchGet
+type BatchResult struct {
+	URL  string
+	Body string
+	Err  error
+}
+
+// BatchGet sends the GET requests to the urls by at most concurrency workers, and returns the results in the order of urls.
+// The urls not yet requested fail with the context error once ctx is done.
+func (client *Client) BatchGet(ctx context.Context, urls []string, concurrency int, reqOpts ...RequestOption) []BatchResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		results = make([]BatchResult, len(urls))
+		indexes = make(chan int)
+		wg      sync.WaitGroup
+	)
+
+	for w := 0; w < concurrency && w < len(urls); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].Body, results[i].Err = client.Get(ctx, urls[i], "", reqOpts...)
+			}
+		}()
+	}
+
+	for i, url := range urls {
+		results[i].URL = url
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,23 @@
+package httpclient
+
+import "context"
+
+// attempt sends the request once within the per-attempt timeout if set,
+// which feeds the circuit breaker if each attempt is counted by WithFailureCounting
+func (client *Client) attempt(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (resp *Response, err error) {
+	if client.perAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.perAttemptTimeout)
+		defer cancel()
+	}
+
+	if client.breaker == nil || !client.countRetries {
+		return client.do(ctx, method, url, body, reqOpts...)
+	}
+
+	err = client.breaker.Run(func() (runErr error) {
+		resp, runErr = client.do(ctx, method, url, body, reqOpts...)
+		return runErr
+	})
+	return resp, err
+}
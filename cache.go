@@ -0,0 +1,247 @@
+package httpclient
+
+import (
+	"container/list"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry is the cached response body with its ETag and freshness
+type cacheEntry struct {
+	url    string
+	etag   string
+	body   string
+	header http.Header
+	// vary is the request header values selecting the response, keyed by the header names listed in `Vary`
+	vary map[string]string
+	// lifetime is the freshness lifetime, and the entry is fresh while its current age is less than it
+	lifetime time.Duration
+	// initialAge is the corrected initial age when the response is received at responseTime
+	initialAge   time.Duration
+	responseTime time.Time
+}
+
+// age returns the current age of the entry at now, see RFC 7234 section 4.2.3
+func (entry *cacheEntry) age(now time.Time) time.Duration {
+	return entry.initialAge + now.Sub(entry.responseTime)
+}
+
+// response returns the cached response served without sending the request
+func (entry *cacheEntry) response(req *http.Request, now time.Time) *Response {
+	header := entry.header.Clone()
+	header.Set("Age", strconv.Itoa(int(entry.age(now)/time.Second)))
+	return &Response{
+		Response: &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(entry.body)),
+			ContentLength: int64(len(entry.body)),
+			Request:       req,
+		},
+		Result: entry.body,
+	}
+}
+
+// freshen updates the freshness of the entry by the response received at responseTime for the request sent at requestTime,
+// see RFC 7234 section 4.2.1 and 4.2.3
+func (entry *cacheEntry) freshen(header http.Header, requestTime, responseTime time.Time) {
+	directives := cacheControl(header)
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = responseTime
+	}
+
+	entry.lifetime = 0
+	if _, ok := directives["no-cache"]; !ok {
+		if maxAge, err := strconv.Atoi(directives["max-age"]); err == nil {
+			entry.lifetime = time.Duration(maxAge) * time.Second
+		} else if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+			entry.lifetime = expires.Sub(date)
+		}
+	}
+
+	apparentAge := responseTime.Sub(date)
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	ageValue, _ := strconv.Atoi(header.Get("Age"))
+	correctedAge := time.Duration(ageValue)*time.Second + responseTime.Sub(requestTime)
+	if correctedAge < apparentAge {
+		correctedAge = apparentAge
+	}
+
+	entry.initialAge = correctedAge
+	entry.responseTime = responseTime
+}
+
+// responseCache is the LRU cache of the GET response bodies keyed by url, revalidated by the ETag once stale
+type responseCache struct {
+	sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+// newResponseCache creates a response cache holding at most size entries
+func newResponseCache(size int) *responseCache {
+	return &responseCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// cacheControl parses the `Cache-Control` header directives
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		if directive = strings.TrimSpace(directive); directive == "" {
+			continue
+		}
+		kv := strings.SplitN(directive, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if len(kv) == 2 {
+			directives[key] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		} else {
+			directives[key] = ""
+		}
+	}
+	return directives
+}
+
+// noStore checks whether the `Cache-Control` header forbids storing the response
+func noStore(header http.Header) bool {
+	_, ok := cacheControl(header)["no-store"]
+	return ok
+}
+
+// credentialed checks whether the request carries the credentials, whose response is never shared by the cache
+func credentialed(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+// varyValues returns the request header values listed in the `Vary` header of the response,
+// ok is false for `Vary: *` which never matches another request
+func varyValues(req *http.Request, header http.Header) (vary map[string]string, ok bool) {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[http.CanonicalHeaderKey(name)] = strings.Join(req.Header.Values(name), ", ")
+		}
+	}
+	return vary, true
+}
+
+// matches checks whether the request selects the cached response by the header values listed in `Vary`
+func (entry *cacheEntry) matches(req *http.Request) bool {
+	for name, value := range entry.vary {
+		if strings.Join(req.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// clock returns the current time of the cache
+func (cache *responseCache) clock() time.Time {
+	if cache == nil {
+		return time.Now()
+	}
+	return cache.now()
+}
+
+// lookup returns the cached response built under the lock if the entry is fresh to be served without sending the request,
+// otherwise the stale entry to be revalidated with the `If-None-Match` header set by its ETag. The request with the
+// credentials, or differing in the header values listed in `Vary` of the cached response, is never served from the cache.
+func (cache *responseCache) lookup(req *http.Request) (stale *cacheEntry, fresh *Response) {
+	if cache == nil || req.Method != "GET" || noStore(req.Header) || req.Header.Get("If-None-Match") != "" || credentialed(req) {
+		return nil, nil
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	elem, ok := cache.entries[req.URL.String()]
+	if !ok {
+		return nil, nil
+	}
+	cache.lru.MoveToFront(elem)
+
+	entry := elem.Value.(*cacheEntry)
+	if !entry.matches(req) {
+		return nil, nil
+	}
+	now := cache.now()
+	if _, noCache := cacheControl(req.Header)["no-cache"]; !noCache && entry.age(now) < entry.lifetime {
+		return nil, entry.response(req, now)
+	}
+
+	req.Header.Set("If-None-Match", entry.etag)
+	return entry, nil
+}
+
+// revalidated freshens the entry by the `304 Not Modified` response
+func (cache *responseCache) revalidated(entry *cacheEntry, resp *http.Response, requestTime time.Time) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	entry.freshen(resp.Header, requestTime, cache.now())
+}
+
+// store caches the response body if the response has an ETag and is allowed to be stored.
+// The responses to the requests with the credentials, `Cache-Control: private` or `Vary: *` are not stored.
+func (cache *responseCache) store(req *http.Request, resp *http.Response, body string, requestTime time.Time) {
+	if cache == nil || req.Method != "GET" || noStore(req.Header) || credentialed(req) {
+		return
+	}
+
+	url := req.URL.String()
+	etag := resp.Header.Get("ETag")
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if elem, ok := cache.entries[url]; ok {
+		cache.lru.Remove(elem)
+		delete(cache.entries, url)
+	}
+	if etag == "" || noStore(resp.Header) {
+		return
+	}
+	if _, private := cacheControl(resp.Header)["private"]; private {
+		return
+	}
+	vary, ok := varyValues(req, resp.Header)
+	if !ok {
+		return
+	}
+
+	entry := &cacheEntry{url: url, etag: etag, body: body, header: resp.Header.Clone(), vary: vary}
+	entry.freshen(resp.Header, requestTime, cache.now())
+
+	cache.entries[url] = cache.lru.PushFront(entry)
+	for cache.lru.Len() > cache.size {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).url)
+	}
+}
@@ -0,0 +1,35 @@
+package httpclient
+
+import (
+	"mime"
+	"net/http"
+
+	"golang.org/x/net/html/charset"
+)
+
+// transcodeCharset transcodes the response body to UTF-8 by the charset of the `Content-Type` header,
+// or by the `<meta>` sniffing for the HTML without the charset. The `Content-Type` charset is updated to utf-8.
+func transcodeCharset(resp *http.Response, data []byte) ([]byte, error) {
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return data, nil
+	}
+
+	if _, ok := params["charset"]; !ok && mediaType != "text/html" {
+		return data, nil
+	}
+
+	enc, name, _ := charset.DetermineEncoding(data, contentType)
+	if enc == nil || name == "utf-8" {
+		return data, nil
+	}
+
+	if data, err = enc.NewDecoder().Bytes(data); err != nil {
+		return nil, err
+	}
+
+	params["charset"] = "utf-8"
+	resp.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	return data, nil
+}
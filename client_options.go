@@ -1,8 +1,16 @@
 package httpclient
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/eapache/go-resiliency/breaker"
+	"golang.org/x/net/http2"
 )
 
 // ClientOption defines the client option to customize the client
@@ -10,9 +18,9 @@ type ClientOption func(*Client)
 
 // DisableRedirect disables to follow 3xx redirection
 func DisableRedirect(client *Client) {
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+	client.setCheckRedirect("DisableRedirect", func(req *http.Request, via []*http.Request) error {
 		return http.ErrUseLastResponse
-	}
+	})
 }
 
 // Timeout set the client request timeout
@@ -25,6 +33,9 @@ func Timeout(timeout time.Duration) ClientOption {
 // SetTransport set the transport of client
 func SetTransport(transport http.RoundTripper) ClientOption {
 	return func(client *Client) {
+		if client.Transport != nil {
+			client.conflict("SetTransport", "the transport set or customized before")
+		}
 		client.Transport = transport
 	}
 }
@@ -42,3 +53,504 @@ func DisableTrafficDebug() ClientOption {
 		client.debugTraffic = false
 	}
 }
+
+// WithBinaryLogSafe logs the binary request and response bodies as the length and base64 summary instead of raw bytes
+func WithBinaryLogSafe() ClientOption {
+	return func(client *Client) {
+		client.binaryLogSafe = true
+	}
+}
+
+// WithAutoCharset transcodes the response body to UTF-8 by the charset of the `Content-Type` header,
+// or by the `<meta>` sniffing for the HTML without the charset.
+func WithAutoCharset() ClientOption {
+	return func(client *Client) {
+		client.autoCharset = true
+	}
+}
+
+// WithDuplicateHeaderPolicy sets how the singleton response headers sent multiple times are handled,
+// e.g. `Content-Type`, which decides the decoding. All the values are kept by default.
+func WithDuplicateHeaderPolicy(policy DuplicateHeaderPolicy) ClientOption {
+	return func(client *Client) {
+		client.dupHeaderPolicy = policy
+	}
+}
+
+// WithRequestID sets the `X-Request-ID` header generated by generator to each request attempt if not present,
+// and adds it to the log context. A random UUID is generated if generator is nil.
+func WithRequestID(generator func() string) ClientOption {
+	return func(client *Client) {
+		if generator == nil {
+			generator = newUUID
+		}
+		client.requestID = generator
+	}
+}
+
+// WithResponsePipeline sets the stages transforming the successful response body in order before it is returned
+// or decoded, e.g. decompressing, unwrapping the envelope. A stage applies to all responses if its Match is nil.
+// The stages run after the content encoding is decoded and the charset is transcoded.
+func WithResponsePipeline(stages []ResponseStage) ClientOption {
+	return func(client *Client) {
+		client.pipeline = stages[:len(stages):len(stages)]
+	}
+}
+
+// WithContentTypeCheck verifies the response Content-Type matches the codec of the JSON or XML client before decoding,
+// e.g. the HTML error page returned by a misconfigured gateway with status 200, which fails with
+// *UnexpectedContentTypeError instead of a confusing decode error. The empty response body is not checked.
+func WithContentTypeCheck() ClientOption {
+	return func(client *Client) {
+		client.contentTypeCheck = true
+	}
+}
+
+// WithJSONSchemaValidation validates the JSON client response body against the schema before decoding
+func WithJSONSchemaValidation(schema []byte) ClientOption {
+	return func(client *Client) {
+		client.jsonSchema = newJSONSchemaValidator(schema)
+	}
+}
+
+// WithRedirectTrace records the redirect chain of each request, and delivers it to fn after the request completes
+func WithRedirectTrace(fn func(hops []RedirectHop)) ClientOption {
+	return func(client *Client) {
+		client.redirectTrace = fn
+	}
+}
+
+// WithDeadlinePropagation sets the remaining time before the context deadline to the header of each request,
+// formatted by the formatter, FormatDeadlineMillis is used if formatter is nil.
+func WithDeadlinePropagation(header string, formatter DeadlineFormatter) ClientOption {
+	return func(client *Client) {
+		if formatter == nil {
+			formatter = FormatDeadlineMillis
+		}
+		client.deadlineHeader = header
+		client.deadlineFormatter = formatter
+	}
+}
+
+// WithCircuitBreaker sends the requests through the circuit breaker, which fails fast by breaker.ErrBreakerOpen once open.
+// Any error of the request counts as a failure, including *HTTPError and the response validator errors.
+func WithCircuitBreaker(b *breaker.Breaker) ClientOption {
+	return func(client *Client) {
+		client.breaker = b
+	}
+}
+
+// WithFailureCounting decides how the retries feed the circuit breaker.
+// If countRetries is false by default, only the final outcome of the request after all the retries counts,
+// so one failed request is one failure. If true, each attempt counts, so the breaker trips sooner under retries,
+// and the remaining retries fail fast by breaker.ErrBreakerOpen once open.
+func WithFailureCounting(countRetries bool) ClientOption {
+	return func(client *Client) {
+		client.countRetries = countRetries
+	}
+}
+
+// WithRetryCoalescing makes the concurrent retries of the identical idempotent requests share one in-flight attempt.
+// Requests with the same method, url, headers and body after the request options are applied are considered identical,
+// and the signed requests are never shared. Each caller stops waiting once its context is done, and the shared attempt
+// goes on while any caller waits for it, it is canceled once all the callers give up.
+func WithRetryCoalescing() ClientOption {
+	return func(client *Client) {
+		client.retryGroup = newFlightGroup()
+	}
+}
+
+// WithSingleFlight makes the concurrent identical idempotent requests share one call including its retries, and all the
+// callers get the copies of the same response and the same error. Requests with the same method, url, headers and body
+// after the request options are applied are considered identical, and the signed requests are never shared. Each caller
+// stops waiting once its context is done, and the shared call goes on while any caller waits for it, it is canceled once
+// all the callers give up.
+func WithSingleFlight() ClientOption {
+	return func(client *Client) {
+		client.flightGroup = newFlightGroup()
+	}
+}
+
+// WithResponseCache enables the in-memory cache of the GET responses with the ETag, holding at most size urls.
+// The fresh response is served without sending the request, by the freshness lifetime of `Cache-Control: max-age`
+// or `Expires`, and the current age computed from the `Date` and `Age` headers per RFC 7234.
+// The stale one is revalidated with the `If-None-Match` header, and the cached body is returned on `304 Not Modified`.
+// Responses with `Cache-Control: no-store` are not cached.
+func WithResponseCache(size int) ClientOption {
+	return func(client *Client) {
+		client.respCache = newResponseCache(size)
+	}
+}
+
+// WithRetryMethods sets the methods allowed to be retried instead of the idempotent ones by default,
+// the requests with the `Idempotency-Key` header are retried regardless of the method.
+func WithRetryMethods(methods ...string) ClientOption {
+	return func(client *Client) {
+		client.retryMethods = make(map[string]bool, len(methods))
+		for _, method := range methods {
+			client.retryMethods[strings.ToUpper(method)] = true
+		}
+	}
+}
+
+// WithMaxRetryElapsedTime stops retrying once the total elapsed time of the attempts and the backoff sleeps would exceed d,
+// and the last error is returned. It is used by the retry policy set by SetRetry, SetBackoffStrategy or ContextWithRetry,
+// but not by the retrier set by SetRetrier.
+func WithMaxRetryElapsedTime(d time.Duration) ClientOption {
+	return func(client *Client) {
+		client.maxRetryElapsed = d
+	}
+}
+
+// WithOnRetry adds the hook invoked before each retry with the number of the failed attempt, its error, and the backoff
+// slept before the next attempt. It is invoked by the retry policy set by SetRetry, SetBackoffStrategy or ContextWithRetry,
+// but not by the retrier set by SetRetrier.
+func WithOnRetry(fn func(attempt int, err error, nextBackoff time.Duration)) ClientOption {
+	return func(client *Client) {
+		client.onRetry = append(client.onRetry[:len(client.onRetry):len(client.onRetry)], fn)
+	}
+}
+
+// WithRequestQuota caps the total number of the requests sent by the client to n, each retry counts as one request.
+// The requests beyond the quota are refused with ErrQuotaExceeded without being sent, while the requests which never
+// reach the wire, e.g. refused by the open circuit breaker, failed by the request options, or canceled while waiting for
+// the slot of WithMaxConcurrency, are not counted.
+func WithRequestQuota(n int) ClientOption {
+	return func(client *Client) {
+		client.quota = &requestQuota{limit: int64(n)}
+	}
+}
+
+// WithMaxConcurrency caps the in-flight requests of the client to n, the others wait for a free slot until the request
+// context is done. The slot is taken while sending, and freed once the response body is closed.
+// The in-flight requests are unlimited if n <= 0.
+func WithMaxConcurrency(n int) ClientOption {
+	return func(client *Client) {
+		if n <= 0 {
+			client.concurrency = nil
+			return
+		}
+		client.concurrency = make(concurrencyLimit, n)
+	}
+}
+
+// WithPerAttemptTimeout sets the timeout of each attempt, which is retried as the timeout error,
+// and the client timeout becomes the ceiling of all the attempts and the backoff sleeps.
+func WithPerAttemptTimeout(d time.Duration) ClientOption {
+	return func(client *Client) {
+		client.perAttemptTimeout = d
+	}
+}
+
+// WithSleepFunc sets the function sleeping the backoff between the retry attempts, instead of the context aware timer.
+// It is used by the retry policy set by SetRetry or ContextWithRetry, but not by the retrier set by SetRetrier.
+func WithSleepFunc(sleep SleepFunc) ClientOption {
+	return func(client *Client) {
+		client.sleep = sleep
+	}
+}
+
+// WithRedirectConfig sets the redirect policy by the config
+func WithRedirectConfig(cfg RedirectConfig) ClientOption {
+	return func(client *Client) {
+		client.setCheckRedirect("WithRedirectConfig", cfg.checkRedirect)
+	}
+}
+
+// WithMaxResponseSize limits the decoded response body to n bytes, ErrResponseTooLarge is returned if exceeded
+func WithMaxResponseSize(n int64) ClientOption {
+	return func(client *Client) {
+		client.maxResponseSize = n
+	}
+}
+
+// WithMaxRedirects follows at most n redirects, an error is returned if exceeded.
+// The redirect response is returned without following if n is not positive.
+func WithMaxRedirects(n int) ClientOption {
+	if n <= 0 {
+		n = -1
+	}
+	cfg := RedirectConfig{MaxRedirects: n}
+	return func(client *Client) {
+		client.setCheckRedirect("WithMaxRedirects", cfg.checkRedirect)
+	}
+}
+
+// WithRedirectPolicy sets the policy inspecting each redirect, see http.Client CheckRedirect.
+// The headers of the redirected request are already copied when fn is called, so that fn is able to strip them,
+// e.g. the `Authorization` header on cross-host redirects.
+func WithRedirectPolicy(fn func(req *http.Request, via []*http.Request) error) ClientOption {
+	return func(client *Client) {
+		client.setCheckRedirect("WithRedirectPolicy", fn)
+	}
+}
+
+// WithJSONCodec sets the JSON codec used by the JSON client, e.g. jsoniter or sonic, encoding/json is used if nil
+func WithJSONCodec(marshal JSONMarshalFunc, unmarshal JSONUnmarshalFunc) ClientOption {
+	return func(client *Client) {
+		client.jsonMarshal = marshal
+		client.jsonUnmarshal = unmarshal
+	}
+}
+
+// WithResponseValidator adds the response validator, which is applied to each successful response
+func WithResponseValidator(validator ResponseValidator) ClientOption {
+	return func(client *Client) {
+		client.respValidators = append(client.respValidators[:len(client.respValidators):len(client.respValidators)], validator)
+	}
+}
+
+// WithContextLogKeys adds the values stored in the request context under keys as the log fields of the requests,
+// e.g. the tenant or the trace id. The field is named by fmt.Sprint(key), so the key type should implement fmt.Stringer
+// if it is not a string.
+func WithContextLogKeys(keys ...interface{}) ClientOption {
+	return func(client *Client) {
+		client.logKeys = append(client.logKeys[:len(client.logKeys):len(client.logKeys)], keys...)
+	}
+}
+
+// WithOnRequest adds the hook invoked before each request is sent, which is able to mutate the request
+func WithOnRequest(fn func(req *http.Request)) ClientOption {
+	return func(client *Client) {
+		client.onRequest = append(client.onRequest[:len(client.onRequest):len(client.onRequest)], fn)
+	}
+}
+
+// WithOnResponse adds the hook invoked after each response is received with the elapsed time, before the body is read.
+// It is not invoked if the request fails without response.
+func WithOnResponse(fn func(resp *http.Response, elapsed time.Duration)) ClientOption {
+	return func(client *Client) {
+		client.onResponse = append(client.onResponse[:len(client.onResponse):len(client.onResponse)], fn)
+	}
+}
+
+// WithStatusValidator sets the validator deciding whether the status code is successful, otherwise *HTTPError is returned.
+// The status code in range [200,300) is successful by default.
+func WithStatusValidator(fn func(code int) bool) ClientOption {
+	return func(client *Client) {
+		client.statusValidator = fn
+	}
+}
+
+// WithConnMaxLifetime sets the max lifetime of the connections regardless of idleness, so that the connections are
+// recreated and rotated through the load balancer. The expiry never interrupts the request in progress, instead the
+// expired connection is retired once the next request picks it, which is sent on a new connection unless its body can't
+// be sent again. The HTTP/2 connections are not retired. It has no effect if the transport is not *http.Transport.
+func WithConnMaxLifetime(d time.Duration) ClientOption {
+	return func(client *Client) {
+		transport := client.transportFor("WithConnMaxLifetime")
+		if transport == nil {
+			return
+		}
+
+		client.connLifetime = true
+		dial := dialContext(transport)
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return newExpiringConn(conn, d), nil
+		}
+	}
+}
+
+// WithDialer dials the connections by dialer, e.g. to bind the local address or set the dial timeout.
+// It replaces the dial function of the transport, so it should come before the options wrapping it, e.g. WithDNSCache.
+// It has no effect if the transport is not *http.Transport.
+func WithDialer(dialer *net.Dialer) ClientOption {
+	return WithDialContext(dialer.DialContext)
+}
+
+// WithDialContext dials the connections by fn, the other transport options are kept.
+// It replaces the dial function of the transport, so it should come before the options wrapping it, e.g. WithDNSCache.
+// It has no effect if the transport is not *http.Transport.
+func WithDialContext(fn DialContextFunc) ClientOption {
+	return func(client *Client) {
+		transport := client.transportFor("WithDialContext")
+		if transport == nil {
+			return
+		}
+
+		transport.DialContext = fn
+	}
+}
+
+// WithDNSCache caches the addresses of the hosts dialed for ttl, so that the new connections skip the repeated lookups.
+// The host is resolved again on miss or expiry, and the expired addresses are used if the lookup fails.
+// It has no effect if the transport is not *http.Transport.
+func WithDNSCache(ttl time.Duration) ClientOption {
+	return withDNSCache(net.DefaultResolver, ttl)
+}
+
+// withDNSCache caches the addresses of the hosts resolved by resolver for ttl
+func withDNSCache(resolver hostResolver, ttl time.Duration) ClientOption {
+	return func(client *Client) {
+		transport := client.transportFor("WithDNSCache")
+		if transport == nil {
+			return
+		}
+
+		transport.DialContext = newDNSCache(resolver, ttl).dialContext(dialContext(transport))
+	}
+}
+
+// WithConnectionWarmup opens n connections to the host of url by the concurrent HEAD requests when the client is created,
+// so that the first requests reuse them without paying the handshake. The connections kept idle are limited by
+// the MaxIdleConnsPerHost of the transport, which is 2 by default. The warmup failures are logged only.
+func WithConnectionWarmup(url string, n int) ClientOption {
+	return func(client *Client) {
+		client.warmupURL = url
+		client.warmupConns = n
+	}
+}
+
+// WithUnixSocket dials the unix domain socket at path for all the requests regardless of the url host,
+// e.g. `http://unix/v1/containers` talks to the local daemon listening on path. The proxy is disabled.
+// It has no effect if the transport is not *http.Transport.
+func WithUnixSocket(path string) ClientOption {
+	return func(client *Client) {
+		transport := client.transportFor("WithUnixSocket")
+		if transport == nil {
+			return
+		}
+
+		dial := dialContext(transport)
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(ctx, "unix", path)
+		}
+	}
+}
+
+// WithStartupProbe sends a HEAD request to url within timeout when the client is created by NewWithError,
+// which fails if the request fails or the status code is not successful. It is ignored by New.
+func WithStartupProbe(url string, timeout time.Duration) ClientOption {
+	return func(client *Client) {
+		probe := func(ctx context.Context, client *Client) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if _, err := client.do(ctx, "HEAD", url, ""); err != nil {
+				return fmt.Errorf("startup probe %v: %w", url, err)
+			}
+			return nil
+		}
+		client.startupProbes = append(client.startupProbes[:len(client.startupProbes):len(client.startupProbes)], probe)
+	}
+}
+
+// WithClientCert presents the client certificate in the TLS handshake for the mutual TLS,
+// which is merged with the other TLS settings of the transport.
+// It has no effect if the transport is not *http.Transport.
+func WithClientCert(cert tls.Certificate) ClientOption {
+	return func(client *Client) {
+		transport := client.transportFor("WithClientCert")
+		if transport == nil {
+			return
+		}
+
+		config := tlsConfig(transport)
+		config.Certificates = append(config.Certificates[:len(config.Certificates):len(config.Certificates)], cert)
+	}
+}
+
+// WithTLSSessionCache resumes the TLS sessions cached in cache, which cuts the handshake cost of the new connections
+// to the same host. A LRU cache of the default capacity is used if cache is nil.
+// It has no effect if the transport is not *http.Transport.
+func WithTLSSessionCache(cache tls.ClientSessionCache) ClientOption {
+	return func(client *Client) {
+		transport := client.transportFor("WithTLSSessionCache")
+		if transport == nil {
+			return
+		}
+
+		if cache == nil {
+			cache = tls.NewLRUClientSessionCache(0)
+		}
+		tlsConfig(transport).ClientSessionCache = cache
+	}
+}
+
+// WithClientCertFromFiles loads the client certificate from the PEM encoded files, and presents it like WithClientCert.
+// The load error naming the files is returned by NewWithError, and the certificate is missing with New.
+func WithClientCertFromFiles(certFile, keyFile string) ClientOption {
+	return func(client *Client) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			if client.optErr == nil {
+				client.optErr = fmt.Errorf("load client cert %v and key %v: %w", certFile, keyFile, err)
+			}
+			return
+		}
+		WithClientCert(cert)(client)
+	}
+}
+
+// WithClientCertFiles loads the client certificate from the PEM encoded files.
+//
+// Deprecated: use WithClientCertFromFiles instead.
+func WithClientCertFiles(certFile, keyFile string) ClientOption {
+	return WithClientCertFromFiles(certFile, keyFile)
+}
+
+// WithForceHTTP1 disables HTTP/2, so that HTTP/1.1 is always used even if the server supports HTTP/2.
+// It has no effect if the transport is not *http.Transport.
+func WithForceHTTP1() ClientOption {
+	return func(client *Client) {
+		client.setHTTPVersion("WithForceHTTP1")
+		transport := client.transportFor("WithForceHTTP1")
+		if transport == nil {
+			return
+		}
+
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+		if transport.TLSClientConfig != nil {
+			config := tlsConfig(transport)
+			nextProtos := config.NextProtos[:0:0]
+			for _, proto := range config.NextProtos {
+				if proto != http2.NextProtoTLS {
+					nextProtos = append(nextProtos, proto)
+				}
+			}
+			config.NextProtos = nextProtos
+		}
+	}
+}
+
+// WithHTTP2 configures HTTP/2 over TLS explicitly by golang.org/x/net/http2, instead of the bundled implementation.
+// It has no effect if the transport is not *http.Transport, or HTTP/2 is already configured.
+func WithHTTP2() ClientOption {
+	return func(client *Client) {
+		client.setHTTPVersion("WithHTTP2")
+		transport := client.transportFor("WithHTTP2")
+		if transport == nil {
+			return
+		}
+
+		// nolint: errcheck
+		http2.ConfigureTransport(transport)
+	}
+}
+
+// RetryIfIncomplete retries the JSON client request if fn returns false on the decoded result, e.g. a required field is
+// missing from the truncated response, or the response fails to decode. The response is decoded into a new value on each
+// attempt, and the result is set once fn accepts it. ErrIncompleteResponse is returned if the retries are exhausted.
+func RetryIfIncomplete(fn func(result interface{}) bool) ClientOption {
+	return func(client *Client) {
+		client.incomplete = fn
+	}
+}
+
+// RetryIfHeader retries the request if fn returns true on the response header, e.g. the gateway signals a degraded mode.
+// ErrRejectedByHeader is returned if the retries are exhausted.
+func RetryIfHeader(fn func(h http.Header) bool) ClientOption {
+	return WithResponseValidator(func(resp *Response) error {
+		if fn(resp.Header) {
+			return Retriable(ErrRejectedByHeader)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,183 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotentMethods are the http methods considered idempotent
+var idempotentMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"PUT":     true,
+	"DELETE":  true,
+}
+
+// requestKey returns the key identifying the identical requests, which is the request with the request options applied
+// under ctx, so that the requests differing in any header, e.g. the credentials, are never shared. The request isn't
+// signed nor stamped for the key, the signed requests are not shared since their signing credentials can't be compared.
+// ok is false if the options fail, the request is signed, or its body can't be read again.
+func (client *Client) requestKey(ctx context.Context, method, url, body string, reqOpts []RequestOption) (key string, ok bool) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+	if ctx, err = client.applyRequestOptions(ctx, req, reqOpts); err != nil {
+		return "", false
+	}
+	if signers, _ := ctx.Value(signerKey{}).([]requestSigner); len(signers) > 0 {
+		return "", false
+	}
+
+	data, ok, err := snapshotBody(req)
+	if req.Body != nil {
+		// nolint: errcheck
+		req.Body.Close()
+	}
+	if err != nil || !ok {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(req.Method + " " + req.URL.String() + " " + req.Host + "\n")
+	if err = req.Header.Write(&buf); err != nil {
+		return "", false
+	}
+	buf.WriteString("\n")
+	buf.Write(data)
+	return buf.String(), true
+}
+
+// detachedContext keeps the values of the parent context, but is never canceled
+type detachedContext struct {
+	context.Context
+}
+
+// Deadline implements the context.Context interface
+func (detachedContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+// Done implements the context.Context interface
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements the context.Context interface
+func (detachedContext) Err() error {
+	return nil
+}
+
+// sendFunc sends the request
+type sendFunc func(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (*Response, error)
+
+// flightCall is the in-flight send shared by its waiters
+type flightCall struct {
+	done    chan struct{}
+	resp    *Response
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup shares the in-flight sends by the request key
+type flightGroup struct {
+	sync.Mutex
+	calls map[string]*flightCall
+}
+
+// newFlightGroup creates an empty flight group
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs send once for the concurrent callers of the same key, and each caller waits for the result until its ctx is done.
+// The send is detached from the cancellation of the caller starting it, and is canceled once all its waiters are gone.
+// Each caller gets its own shallow copy of the shared response.
+func (group *flightGroup) do(ctx context.Context, key string, send func(ctx context.Context) (*Response, error)) (*Response, error) {
+	group.Lock()
+	call, ok := group.calls[key]
+	if !ok {
+		sendCtx, cancel := context.WithCancel(detachedContext{ctx})
+		call = &flightCall{done: make(chan struct{}), cancel: cancel}
+		group.calls[key] = call
+
+		go func() {
+			defer cancel()
+			call.resp, call.err = send(sendCtx)
+
+			group.Lock()
+			if group.calls[key] == call {
+				delete(group.calls, key)
+			}
+			group.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	group.Unlock()
+
+	select {
+	case <-call.done:
+		return call.resp.copy(), call.err
+	case <-ctx.Done():
+		group.Lock()
+		if call.waiters--; call.waiters == 0 {
+			// the abandoned send is canceled, and the later callers start a new one
+			call.cancel()
+			if group.calls[key] == call {
+				delete(group.calls, key)
+			}
+		}
+		group.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// copy returns the shallow copy of the response, so that the callers sharing it don't see the changes of each other
+func (resp *Response) copy() *Response {
+	if resp == nil {
+		return nil
+	}
+	shared := *resp
+	if resp.Response != nil {
+		httpResp := *resp.Response
+		httpResp.Header = resp.Header.Clone()
+		shared.Response = &httpResp
+	}
+	return &shared
+}
+
+// share sends the request by send in group, the concurrent identical requests share one in-flight send and its result.
+// Only the idempotent methods are shared, and the requests with the deadline header are not, since the deadline of each
+// caller differs.
+func (client *Client) share(ctx context.Context, group *flightGroup, send sendFunc, method, url, body string, reqOpts []RequestOption) (*Response, error) {
+	if !idempotentMethods[method] || client.deadlineHeader != "" {
+		return send(ctx, method, url, body, reqOpts...)
+	}
+
+	key, ok := client.requestKey(ctx, method, url, body, reqOpts)
+	if !ok {
+		return send(ctx, method, url, body, reqOpts...)
+	}
+
+	return group.do(ctx, key, func(ctx context.Context) (*Response, error) {
+		return send(ctx, method, url, body, reqOpts...)
+	})
+}
+
+// doCoalesced sends the request in the retry group, the concurrent identical requests share one in-flight attempt and its result
+func (client *Client) doCoalesced(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (*Response, error) {
+	return client.share(ctx, client.retryGroup, client.attempt, method, url, body, reqOpts)
+}
+
+// doShared sends the request in the flight group, the concurrent identical requests share one call including its retries,
+// and all of them get the same response and error
+func (client *Client) doShared(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (*Response, error) {
+	return client.share(ctx, client.flightGroup, client.doResponse, method, url, body, reqOpts)
+}
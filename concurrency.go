@@ -0,0 +1,44 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// concurrencyLimit is the semaphore limiting the in-flight requests by its capacity
+type concurrencyLimit chan struct{}
+
+// acquire waits for a free slot until ctx is done
+func (sem concurrencyLimit) acquire(ctx context.Context) error {
+	if sem == nil {
+		return nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by acquire
+func (sem concurrencyLimit) release() {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// releasingBody is the response body releasing the slot of the request once closed
+type releasingBody struct {
+	io.ReadCloser
+	once sync.Once
+	sem  concurrencyLimit
+}
+
+// Close implements the io.Closer interface
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.sem.release)
+	return err
+}
@@ -0,0 +1,56 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeadlineFormatter formats the remaining time before the context deadline as the header value
+type DeadlineFormatter func(remaining time.Duration) string
+
+// FormatDeadlineMillis formats the remaining time in milliseconds, e.g. `1500`
+func FormatDeadlineMillis(remaining time.Duration) string {
+	return strconv.FormatInt(int64(remaining/time.Millisecond), 10)
+}
+
+// grpcTimeoutUnits are the units of the grpc-timeout header, from the finest to the coarsest
+var grpcTimeoutUnits = []struct {
+	unit   time.Duration
+	suffix string
+}{
+	{time.Nanosecond, "n"},
+	{time.Microsecond, "u"},
+	{time.Millisecond, "m"},
+	{time.Second, "S"},
+	{time.Minute, "M"},
+	{time.Hour, "H"},
+}
+
+// FormatGRPCTimeout formats the remaining time in the grpc-timeout style, e.g. `1500000u`.
+// The finest unit that keeps the value within 8 digits is used.
+func FormatGRPCTimeout(remaining time.Duration) string {
+	const maxValue = 100000000
+	for _, u := range grpcTimeoutUnits {
+		if v := int64(remaining / u.unit); v < maxValue {
+			return strconv.FormatInt(v, 10) + u.suffix
+		}
+	}
+	last := grpcTimeoutUnits[len(grpcTimeoutUnits)-1]
+	return strconv.FormatInt(int64(remaining/last.unit), 10) + last.suffix
+}
+
+// propagateDeadline sets the remaining time before the context deadline to the request header
+func propagateDeadline(ctx context.Context, req *http.Request, header string, formatter DeadlineFormatter) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	req.Header.Set(header, formatter(remaining))
+}
@@ -0,0 +1,436 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/std0d9k81/log"
+)
+
+// copyResponse copies the decoded response body to out, and reports the progress if set in context.
+// The offset is the size already downloaded before this response.
+func copyResponse(ctx context.Context, out io.Writer, resp *http.Response, offset int64) (written int64, err error) {
+	var (
+		body   io.Reader = resp.Body
+		reader io.ReadCloser
+	)
+
+	// the progress is counted on the wire, since the total comes from the Content-Length
+	progress := progressFromContext(ctx)
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	counter := &progressReader{Reader: body, written: offset, total: total, progress: progress}
+	if progress != nil {
+		body = counter
+	}
+
+	if reader, err = decodeBody(body, resp.Header.Get("Content-Encoding")); err != nil {
+		return 0, err
+	}
+	// nolint: errcheck
+	defer reader.Close()
+
+	if written, err = io.Copy(out, reader); err != nil {
+		return written, err
+	}
+
+	if progress != nil {
+		progress(counter.written, total)
+	}
+	return written, nil
+}
+
+// expectETagKey is the context key of the ETag expected by ExpectETag
+type expectETagKey struct{}
+
+// ExpectETag verifies the ETag of the download response, which fails with *ETagMismatchError before anything is written
+// if the `ETag` header differs from etag. The ETags are compared as is, including the quotes and the `W/` prefix.
+func ExpectETag(etag string) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return context.WithValue(ctx, expectETagKey{}, etag), nil
+	}
+}
+
+// checkETag checks the response ETag against the one expected by ExpectETag if set
+func checkETag(resp *http.Response) error {
+	expected, ok := resp.Request.Context().Value(expectETagKey{}).(string)
+	if !ok {
+		return nil
+	}
+	if actual := resp.Header.Get("ETag"); actual != expected {
+		return &ETagMismatchError{Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// lazyFile is the file created on the first write, so that the existing file is left untouched
+// if the download fails before any data is received
+type lazyFile struct {
+	name string
+	file *os.File
+}
+
+// Write implements the io.Writer interface
+func (f *lazyFile) Write(p []byte) (n int, err error) {
+	if err = f.create(); err != nil {
+		return 0, err
+	}
+	return f.file.Write(p)
+}
+
+// create creates the file if not created yet
+func (f *lazyFile) create() (err error) {
+	if f.file == nil {
+		f.file, err = os.Create(f.name)
+	}
+	return err
+}
+
+// Close closes the file if created
+func (f *lazyFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// DownloadFile download file from url
+func (client *Client) DownloadFile(ctx context.Context, url, outFile string, reqOpts ...RequestOption) (err error) {
+	out := &lazyFile{name: outFile}
+	defer out.Close()
+
+	ctx = log.WithContext(ctx, "out_file", outFile)
+
+	if _, err = client.Download(ctx, url, out, reqOpts...); err != nil {
+		return err
+	}
+
+	// create the file for the empty response body
+	if err = out.create(); err != nil {
+		log.Error(ctx, "create download file", "url", url, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Download downloads from url, and writes the decoded response body to w
+func (client *Client) Download(ctx context.Context, url string, w io.Writer, reqOpts ...RequestOption) (written int64, err error) {
+	var (
+		req    *http.Request
+		resp   *http.Response
+		method = "GET"
+	)
+
+	if req, err = http.NewRequest(method, url, nil); err != nil {
+		return 0, err
+	}
+
+	if ctx, err = client.prepareRequest(ctx, req, reqOpts); err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	ctx = log.WithContext(ctx,
+		"method", method,
+		"url", req.URL.String(),
+	)
+
+	begin := time.Now()
+	resp, err = client.send(req)
+	if err != nil {
+		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
+		return 0, err
+	}
+	// nolint: errcheck
+	defer resp.Body.Close()
+
+	if !client.isSuccess(resp.StatusCode) {
+		err = newHTTPError(resp)
+		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
+		return 0, err
+	}
+
+	if err = checkETag(resp); err != nil {
+		log.Error(ctx, "verify etag", "error", err, "proc_time", time.Since(begin))
+		return 0, err
+	}
+
+	if written, err = copyResponse(ctx, w, resp, 0); err != nil {
+		log.Error(ctx, "copy response data", "error", err, "proc_time", time.Since(begin))
+		return written, err
+	}
+
+	log.Debug(ctx, "request success", "size", written, "proc_time", time.Since(begin))
+
+	return written, nil
+}
+
+// parseContentRange parses the `Content-Range: bytes <start>-<end>/<size>` header, size is -1 if unknown.
+// For the unsatisfied range `bytes */<size>`, start is -1.
+func parseContentRange(contentRange string) (start, size int64, err error) {
+	var (
+		end     int64
+		sizeStr string
+	)
+
+	if _, err = fmt.Sscanf(contentRange, "bytes */%d", &size); err == nil {
+		return -1, size, nil
+	}
+
+	if _, err = fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &sizeStr); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %v", contentRange)
+	}
+
+	if sizeStr == "*" {
+		return start, -1, nil
+	}
+
+	if _, err = fmt.Sscanf(sizeStr, "%d", &size); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %v", contentRange)
+	}
+	return start, size, nil
+}
+
+// ResumeDownloadFile resumes downloading file from url, the existing out file is continued with a Range request.
+// If the server ignores the Range request and returns the whole body, the out file is truncated and rewritten.
+func (client *Client) ResumeDownloadFile(ctx context.Context, url, outFile string, reqOpts ...RequestOption) (err error) {
+	var (
+		req    *http.Request
+		resp   *http.Response
+		offset int64
+		method = "GET"
+	)
+
+	if fi, statErr := os.Stat(outFile); statErr == nil {
+		offset = fi.Size()
+	} else if !os.IsNotExist(statErr) {
+		return statErr
+	}
+
+	if req, err = http.NewRequest(method, url, nil); err != nil {
+		return err
+	}
+
+	if ctx, err = client.prepareRequest(ctx, req, reqOpts); err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	ctx = log.WithContext(ctx,
+		"method", method,
+		"url", req.URL.String(),
+		"out_file", outFile,
+		"offset", offset,
+	)
+
+	begin := time.Now()
+	resp, err = client.send(req)
+	if err != nil {
+		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+	// nolint: errcheck
+	defer resp.Body.Close()
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		var start int64
+		if start, _, err = parseContentRange(resp.Header.Get("Content-Range")); err != nil {
+			log.Error(ctx, "parse content range", "error", err, "proc_time", time.Since(begin))
+			return err
+		}
+		if start != offset {
+			err = fmt.Errorf("unexpected range start: %v, expected: %v", start, offset)
+			log.Error(ctx, "bad content range", "error", err, "proc_time", time.Since(begin))
+			return err
+		}
+		flag = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the file is already complete if the range starts right at the end of it
+		if _, size, parseErr := parseContentRange(resp.Header.Get("Content-Range")); parseErr == nil && size == offset {
+			log.Debug(ctx, "download already completed", "file_size", offset, "proc_time", time.Since(begin))
+			return nil
+		}
+		err = newHTTPError(resp)
+		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
+		return err
+	case !client.isSuccess(resp.StatusCode):
+		err = newHTTPError(resp)
+		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
+		return err
+	default:
+		// the server ignores the Range request, and returns the whole body
+		offset = 0
+	}
+
+	if err = checkETag(resp); err != nil {
+		log.Error(ctx, "verify etag", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	out, err := os.OpenFile(outFile, flag, 0666)
+	if err != nil {
+		log.Error(ctx, "open download file", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+	defer out.Close()
+
+	written, err := copyResponse(ctx, out, resp, offset)
+	if err != nil {
+		log.Error(ctx, "copy response data to download file", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	log.Debug(ctx, "request success", "file_size", offset+written, "proc_time", time.Since(begin))
+
+	return nil
+}
+
+// createTempFile creates the temp file next to outFile with the same permissions as os.Create,
+// which is renamed to outFile by commitTempFile once the download completes
+func createTempFile(outFile string) (*os.File, error) {
+	return os.OpenFile(outFile+"."+newUUID()+".tmp", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+}
+
+// commitTempFile closes the temp file and renames it to outFile, the temp file is removed if it fails
+func commitTempFile(tmp *os.File, outFile string) error {
+	err := tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), outFile)
+	}
+	if err != nil {
+		// nolint: errcheck
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// discardTempFile closes and removes the temp file of the failed download
+func discardTempFile(tmp *os.File) {
+	// nolint: errcheck
+	tmp.Close()
+	// nolint: errcheck
+	os.Remove(tmp.Name())
+}
+
+// etagFile returns the sidecar file storing the ETag of the downloaded file
+func etagFile(outFile string) string {
+	return outFile + ".etag"
+}
+
+// DownloadIfChanged downloads file from url only if it is changed since the last download.
+// The ETag is stored in the sidecar file `<outFile>.etag`, and sent by `If-None-Match`,
+// the mtime of the out file is sent by `If-Modified-Since`.
+// The out file is left untouched and changed is false if the server responds 304 Not Modified, or the download fails.
+func (client *Client) DownloadIfChanged(ctx context.Context, url, outFile string, reqOpts ...RequestOption) (changed bool, err error) {
+	var (
+		req    *http.Request
+		resp   *http.Response
+		method = "GET"
+	)
+
+	if req, err = http.NewRequest(method, url, nil); err != nil {
+		return false, err
+	}
+
+	if ctx, err = client.prepareRequest(ctx, req, reqOpts); err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+
+	if fi, statErr := os.Stat(outFile); statErr == nil {
+		if etag, readErr := ioutil.ReadFile(etagFile(outFile)); readErr == nil && len(etag) > 0 {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+		req.Header.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	ctx = log.WithContext(ctx,
+		"method", method,
+		"url", req.URL.String(),
+		"out_file", outFile,
+	)
+
+	begin := time.Now()
+	resp, err = client.send(req)
+	if err != nil {
+		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
+		return false, err
+	}
+	// nolint: errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		log.Debug(ctx, "file not modified", "proc_time", time.Since(begin))
+		return false, nil
+	}
+
+	if !client.isSuccess(resp.StatusCode) {
+		err = newHTTPError(resp)
+		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
+		return false, err
+	}
+
+	if err = checkETag(resp); err != nil {
+		log.Error(ctx, "verify etag", "error", err, "proc_time", time.Since(begin))
+		return false, err
+	}
+
+	// the response is written to the temp file renamed to the out file once complete, so that a failed download
+	// leaves the previous file and its ETag as they were
+	out, err := createTempFile(outFile)
+	if err != nil {
+		log.Error(ctx, "create download file", "error", err, "proc_time", time.Since(begin))
+		return false, err
+	}
+
+	written, err := copyResponse(ctx, out, resp, 0)
+	if err != nil {
+		discardTempFile(out)
+		log.Error(ctx, "copy response data to download file", "error", err, "proc_time", time.Since(begin))
+		return false, err
+	}
+
+	if lastModified, parseErr := http.ParseTime(resp.Header.Get("Last-Modified")); parseErr == nil {
+		if err = os.Chtimes(out.Name(), lastModified, lastModified); err != nil {
+			discardTempFile(out)
+			log.Error(ctx, "set download file mtime", "error", err, "proc_time", time.Since(begin))
+			return false, err
+		}
+	}
+
+	// the stale ETag is removed first, so that it never pairs with the new content
+	if err = os.Remove(etagFile(outFile)); err != nil && !os.IsNotExist(err) {
+		discardTempFile(out)
+		log.Error(ctx, "remove etag file", "error", err, "proc_time", time.Since(begin))
+		return false, err
+	}
+
+	if err = commitTempFile(out, outFile); err != nil {
+		log.Error(ctx, "rename download file", "error", err, "proc_time", time.Since(begin))
+		return false, err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if err = ioutil.WriteFile(etagFile(outFile), []byte(etag), 0666); err != nil {
+			log.Error(ctx, "store etag file", "error", err, "proc_time", time.Since(begin))
+			return true, err
+		}
+	}
+
+	log.Debug(ctx, "request success", "file_size", written, "proc_time", time.Since(begin))
+
+	return true, nil
+}
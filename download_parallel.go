@@ -0,0 +1,175 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/std0d9k81/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// offsetWriter writes to the file from the offset
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+// Write implements the io.Writer interface
+func (w *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// getRange sends the GET request of the byte range [start, end]
+func (client *Client) getRange(ctx context.Context, url string, start, end int64, reqOpts []RequestOption) (resp *http.Response, err error) {
+	var req *http.Request
+
+	if req, err = http.NewRequest("GET", url, nil); err != nil {
+		return nil, err
+	}
+
+	if ctx, err = client.prepareRequest(ctx, req, reqOpts); err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	return client.send(req)
+}
+
+// downloadRange downloads the byte range [start, end] to the same offset of the out file
+func (client *Client) downloadRange(ctx context.Context, url string, out *os.File, start, end int64, reqOpts []RequestOption) error {
+	ctx = log.WithContext(ctx, "range_start", start, "range_end", end)
+
+	begin := time.Now()
+	resp, err := client.getRange(ctx, url, start, end, reqOpts)
+	if err != nil {
+		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+	// nolint: errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		err = newHTTPError(resp)
+		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	if err = checkETag(resp); err != nil {
+		log.Error(ctx, "verify etag", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	var rangeStart int64
+	if rangeStart, _, err = parseContentRange(resp.Header.Get("Content-Range")); err != nil {
+		log.Error(ctx, "parse content range", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+	if rangeStart != start {
+		err = fmt.Errorf("unexpected range start: %v, expected: %v", rangeStart, start)
+		log.Error(ctx, "bad content range", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	written, err := io.Copy(&offsetWriter{file: out, offset: start}, io.LimitReader(resp.Body, end-start+1))
+	if err == nil && written != end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		log.Error(ctx, "copy response data to download file", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	log.Debug(ctx, "range downloaded", "size", written, "proc_time", time.Since(begin))
+	return nil
+}
+
+// DownloadFileParallel downloads file from url by parts concurrent Range requests into the distinct file offsets.
+// It falls back to DownloadFile if the server doesn't support Range requests, or the file size is unknown.
+// The out file is replaced only once all the parts are downloaded.
+func (client *Client) DownloadFileParallel(ctx context.Context, url, outFile string, parts int, reqOpts ...RequestOption) (err error) {
+	var (
+		resp *http.Response
+		size int64 = -1
+	)
+
+	if parts < 1 {
+		parts = 1
+	}
+
+	ctx = log.WithContext(ctx,
+		"url", url,
+		"out_file", outFile,
+		"parts", parts,
+	)
+
+	// probe the range support and the file size by the first byte
+	begin := time.Now()
+	if resp, err = client.getRange(ctx, url, 0, 0, reqOpts); err != nil {
+		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+	// nolint: errcheck
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPartialContent {
+		if _, size, err = parseContentRange(resp.Header.Get("Content-Range")); err != nil {
+			size = -1
+		}
+	}
+
+	if size <= 0 || resp.Header.Get("Content-Encoding") != "" {
+		log.Debug(ctx, "range not supported, fall back to single stream", "status", resp.StatusCode)
+		return client.DownloadFile(ctx, url, outFile, reqOpts...)
+	}
+
+	if err = checkETag(resp); err != nil {
+		log.Error(ctx, "verify etag", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	// the parts are written to the temp file renamed to the out file once all of them complete,
+	// so that a failed download never leaves the out file with the holes of the missing parts
+	out, err := createTempFile(outFile)
+	if err != nil {
+		log.Error(ctx, "create download file", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	if err = out.Truncate(size); err != nil {
+		discardTempFile(out)
+		log.Error(ctx, "truncate download file", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	partSize := (size + int64(parts) - 1) / int64(parts)
+	group, groupCtx := errgroup.WithContext(ctx)
+	for start := int64(0); start < size; start += partSize {
+		start, end := start, start+partSize-1
+		if end >= size {
+			end = size - 1
+		}
+		group.Go(func() error {
+			return client.downloadRange(groupCtx, url, out, start, end, reqOpts)
+		})
+	}
+
+	if err = group.Wait(); err != nil {
+		discardTempFile(out)
+		return err
+	}
+
+	if err = commitTempFile(out, outFile); err != nil {
+		log.Error(ctx, "rename download file", "error", err, "proc_time", time.Since(begin))
+		return err
+	}
+
+	log.Debug(ctx, "request success", "file_size", size, "proc_time", time.Since(begin))
+	return nil
+}
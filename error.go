@@ -1,14 +1,212 @@
 package httpclient
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
 
-// HTTPError is the http error status code info, which is not in range [200,300)
+// ErrResponseTooLarge is the error that the decoded response body exceeds the limit set by WithMaxResponseSize
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ErrUnexpectedContentType is the error that the response Content-Type doesn't match the codec of the client,
+// which is wrapped by *UnexpectedContentTypeError
+var ErrUnexpectedContentType = errors.New("unexpected content type")
+
+// maxErrorBodySize is the max size of the response body kept in HTTPError
+const maxErrorBodySize = 64 * 1024
+
+// HTTPError is the http error status code info, which is not in range [200,300) or rejected by the status validator
 type HTTPError struct {
 	StatusCode int
 	StatusText string
+	Header     http.Header
+	Body       string
 }
 
-// Error implements the error interface
+// newHTTPError creates the HTTPError, the decoded response body is read as text, capped to maxErrorBodySize
+func newHTTPError(resp *http.Response) *HTTPError {
+	err := &HTTPError{
+		StatusCode: resp.StatusCode,
+		StatusText: resp.Status,
+		Header:     resp.Header,
+	}
+
+	reader, decodeErr := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if decodeErr != nil {
+		return err
+	}
+	// nolint: errcheck
+	defer reader.Close()
+
+	// the body is for diagnostics only, keep what is read even if the read fails
+	data, _ := ioutil.ReadAll(io.LimitReader(reader, maxErrorBodySize))
+	err.Body = string(data)
+	return err
+}
+
+// Error implements the error interface, the response body is included as the snippet capped to maxDecodeErrorBodySize
 func (e *HTTPError) Error() string {
-	return fmt.Sprintf("HTTP Error: %v, %v", e.StatusCode, e.StatusText)
+	if e.Body == "" {
+		return fmt.Sprintf("HTTP Error: %v, %v", e.StatusCode, e.StatusText)
+	}
+
+	body := e.Body
+	if len(body) > maxDecodeErrorBodySize {
+		body = body[:maxDecodeErrorBodySize] + "..."
+	}
+	return fmt.Sprintf("HTTP Error: %v, %v, body: %q", e.StatusCode, e.StatusText, body)
+}
+
+// ResponseBody returns the response body capped to maxErrorBodySize, which usually has the error details of the API
+func (e *HTTPError) ResponseBody() string {
+	return e.Body
+}
+
+// IsClientError checks whether the status code is 4xx, the request should be fixed instead of retried
+func (e *HTTPError) IsClientError() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// IsServerError checks whether the status code is 5xx, the request may succeed if retried
+func (e *HTTPError) IsServerError() bool {
+	return e.StatusCode >= 500 && e.StatusCode < 600
+}
+
+// ErrorCategory is the category of HTTPError by the status code class
+type ErrorCategory int
+
+// The categories of HTTPError
+const (
+	// CategoryOther is the status code out of 4xx and 5xx, e.g. the 3xx not followed or rejected by the status validator
+	CategoryOther ErrorCategory = iota
+	// CategoryClientError is the 4xx status code
+	CategoryClientError
+	// CategoryServerError is the 5xx status code
+	CategoryServerError
+)
+
+// String implements the fmt.Stringer interface
+func (c ErrorCategory) String() string {
+	switch c {
+	case CategoryClientError:
+		return "client error"
+	case CategoryServerError:
+		return "server error"
+	default:
+		return "other"
+	}
+}
+
+// Category returns the category of the error by the status code
+func (e *HTTPError) Category() ErrorCategory {
+	switch {
+	case e.IsClientError():
+		return CategoryClientError
+	case e.IsServerError():
+		return CategoryServerError
+	default:
+		return CategoryOther
+	}
+}
+
+// maxPartialBodySize is the max size of the partially-read body kept in PartialBodyError
+const maxPartialBodySize = 64 * 1024
+
+// PartialBodyError is the error reading the response body, with the partially-read body for diagnostics
+type PartialBodyError struct {
+	Bytes []byte
+	Err   error
+}
+
+// newPartialBodyError creates the PartialBodyError, the partially-read body is capped to maxPartialBodySize
+func newPartialBodyError(data []byte, err error) *PartialBodyError {
+	if len(data) > maxPartialBodySize {
+		data = data[:maxPartialBodySize]
+	}
+	return &PartialBodyError{Bytes: data, Err: err}
+}
+
+// Error implements the error interface
+func (e *PartialBodyError) Error() string {
+	return fmt.Sprintf("read response body: %v, %v bytes read", e.Err, len(e.Bytes))
+}
+
+// Unwrap returns the underlying read error
+func (e *PartialBodyError) Unwrap() error {
+	return e.Err
+}
+
+// maxDecodeErrorBodySize is the max size of the response body snippet kept in DecodeError
+const maxDecodeErrorBodySize = 512
+
+// DecodeError is the error decoding the response body, with the response body snippet for diagnostics
+type DecodeError struct {
+	Err         error
+	Body        string
+	ContentType string
+	StatusCode  int
+}
+
+// newDecodeError creates the DecodeError, the response body is capped to maxDecodeErrorBodySize
+func newDecodeError(resp *Response, err error) *DecodeError {
+	body := resp.Result
+	if len(body) > maxDecodeErrorBodySize {
+		body = body[:maxDecodeErrorBodySize] + "..."
+	}
+	return &DecodeError{
+		Err:         err,
+		Body:        body,
+		ContentType: resp.Header.Get("Content-Type"),
+		StatusCode:  resp.StatusCode,
+	}
+}
+
+// Error implements the error interface
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Decode Error: %v, status: %v, content type: %v, body: %q", e.Err, e.StatusCode, e.ContentType, e.Body)
+}
+
+// Unwrap returns the underlying decode error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ETagMismatchError is the error that the downloaded response ETag differs from the one expected by ExpectETag
+type ETagMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+// Error implements the error interface
+func (e *ETagMismatchError) Error() string {
+	return fmt.Sprintf("ETag Mismatch: expected %v, actual %v", e.Expected, e.Actual)
+}
+
+// UnexpectedContentTypeError is the error that the response Content-Type is rejected by WithContentTypeCheck,
+// with the response body snippet for diagnostics
+type UnexpectedContentTypeError struct {
+	ContentType string
+	Body        string
+}
+
+// newUnexpectedContentTypeError creates the UnexpectedContentTypeError, the response body is capped to maxDecodeErrorBodySize
+func newUnexpectedContentTypeError(resp *Response) *UnexpectedContentTypeError {
+	body := resp.Result
+	if len(body) > maxDecodeErrorBodySize {
+		body = body[:maxDecodeErrorBodySize] + "..."
+	}
+	return &UnexpectedContentTypeError{ContentType: resp.Header.Get("Content-Type"), Body: body}
+}
+
+// Error implements the error interface
+func (e *UnexpectedContentTypeError) Error() string {
+	return fmt.Sprintf("%v: %q, body: %q", ErrUnexpectedContentType, e.ContentType, e.Body)
+}
+
+// Unwrap returns ErrUnexpectedContentType
+func (e *UnexpectedContentTypeError) Unwrap() error {
+	return ErrUnexpectedContentType
 }
@@ -0,0 +1,25 @@
+package httpclient
+
+import "net/http"
+
+// retryWithoutExpect retries the request without the Expect header, after the server responds 417 Expectation Failed.
+// The 417 response is returned if the request body can not be sent again.
+func (client *Client) retryWithoutExpect(req *http.Request, resp *http.Response) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	// nolint: errcheck
+	resp.Body.Close()
+
+	newReq := req.Clone(req.Context())
+	newReq.Header.Del("Expect")
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		newReq.Body = body
+	}
+	return client.sendTraced(newReq)
+}
@@ -0,0 +1,48 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/std0d9k81/log"
+)
+
+// DuplicateHeaderPolicy decides how the singleton response header sent multiple times is handled
+type DuplicateHeaderPolicy int
+
+const (
+	// DuplicateHeaderKeep keeps all the values, and Header.Get returns the first one
+	DuplicateHeaderKeep DuplicateHeaderPolicy = iota
+	// DuplicateHeaderFirst keeps the first value only
+	DuplicateHeaderFirst
+	// DuplicateHeaderLast keeps the last value only
+	DuplicateHeaderLast
+)
+
+// singletonHeaders are the response headers expected to be sent at most once
+var singletonHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Content-Range",
+	"Location",
+	"ETag",
+	"Last-Modified",
+}
+
+// dedupHeaders logs the duplicate singleton headers, and keeps one of the values by the duplicate header policy
+func (client *Client) dedupHeaders(ctx context.Context, header http.Header) {
+	for _, key := range singletonHeaders {
+		values := header.Values(key)
+		if len(values) <= 1 {
+			continue
+		}
+
+		log.Warning(ctx, "duplicate response header", "header", key, "values", values)
+		switch client.dupHeaderPolicy {
+		case DuplicateHeaderFirst:
+			header.Set(key, values[0])
+		case DuplicateHeaderLast:
+			header.Set(key, values[len(values)-1])
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrHTTP10Unsupported is the error that the request in HTTP/1.0 can't be sent by the client transport
+var ErrHTTP10Unsupported = errors.New("HTTP/1.0 unsupported by the transport")
+
+// http10Transport sends the request in HTTP/1.0 on a new connection each time,
+// since the http.Transport always sends HTTP/1.1 or HTTP/2 regardless of the request Proto.
+// The connection is dialed by the dial functions, the proxy and the TLS config of the client transport.
+type http10Transport struct {
+	transport *http.Transport
+}
+
+// httpClient returns the http client sending the request, which is a copy with the HTTP/1.0 transport
+// if the request Proto is HTTP/1.0
+func (client *Client) httpClient(req *http.Request) (*http.Client, error) {
+	if req.ProtoMajor != 1 || req.ProtoMinor != 0 {
+		return client.Client, nil
+	}
+
+	transport, err := newHTTP10RoundTripper(client.Transport)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := *client.Client
+	httpClient.Transport = transport
+	return &httpClient, nil
+}
+
+// newHTTP10RoundTripper returns the round tripper sending in HTTP/1.0 by rt, the OAuth2 transport is kept wrapping it.
+// It fails with ErrHTTP10Unsupported if rt is neither *http.Transport nor the OAuth2 transport wrapping it.
+func newHTTP10RoundTripper(rt http.RoundTripper) (http.RoundTripper, error) {
+	switch t := rt.(type) {
+	case nil:
+		return &http10Transport{transport: http.DefaultTransport.(*http.Transport)}, nil
+	case *http.Transport:
+		return &http10Transport{transport: t}, nil
+	case *oauth2.Transport:
+		base, err := newHTTP10RoundTripper(t.Base)
+		if err != nil {
+			return nil, err
+		}
+		return &oauth2.Transport{Source: t.Source, Base: base}, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrHTTP10Unsupported, rt)
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *http10Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		body []byte
+		err  error
+	)
+
+	// HTTP/1.0 has no chunked transfer encoding, the body is buffered to send the Content-Length
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		// nolint: errcheck
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	proxyURL, err := t.proxy(req)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := t.dial(req.Context(), req.URL, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := req.Context().Deadline(); ok {
+		// nolint: errcheck
+		conn.SetDeadline(deadline)
+	}
+
+	buf := &bytes.Buffer{}
+	// the plain http request is sent to the proxy in the absolute form
+	requestURI := req.URL.RequestURI()
+	if proxyURL != nil && !strings.EqualFold(req.URL.Scheme, "https") {
+		requestURI = req.URL.String()
+	}
+	fmt.Fprintf(buf, "%s %s HTTP/1.0\r\n", req.Method, requestURI)
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(buf, "Host: %s\r\n", host)
+	if len(body) > 0 || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		fmt.Fprintf(buf, "Content-Length: %d\r\n", len(body))
+	}
+	if proxyURL != nil && !strings.EqualFold(req.URL.Scheme, "https") {
+		if auth := proxyAuthorization(proxyURL); auth != "" {
+			fmt.Fprintf(buf, "Proxy-Authorization: %s\r\n", auth)
+		}
+	}
+	if err = req.Header.WriteSubset(buf, map[string]bool{"Host": true, "Content-Length": true}); err != nil {
+		// nolint: errcheck
+		conn.Close()
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	if _, err = conn.Write(buf.Bytes()); err != nil {
+		// nolint: errcheck
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		// nolint: errcheck
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &connBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// proxy returns the proxy url of the request by the transport, only the http and https proxies are supported
+func (t *http10Transport) proxy(req *http.Request) (*url.URL, error) {
+	if t.transport.Proxy == nil {
+		return nil, nil
+	}
+
+	proxyURL, err := t.transport.Proxy(req)
+	if err != nil || proxyURL == nil {
+		return nil, err
+	}
+	if scheme := strings.ToLower(proxyURL.Scheme); scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("%w: proxy scheme %v", ErrHTTP10Unsupported, proxyURL.Scheme)
+	}
+	return proxyURL, nil
+}
+
+// canonicalAddr returns the host:port of the url, with the default port of the scheme if missing
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// proxyAuthorization returns the basic authorization of the proxy url user if any
+func proxyAuthorization(proxyURL *url.URL) string {
+	if proxyURL.User == nil {
+		return ""
+	}
+	password, _ := proxyURL.User.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username()+":"+password))
+}
+
+// dial dials the connection to the target url, through the proxy if not nil, by TLS for https
+func (t *http10Transport) dial(ctx context.Context, target, proxyURL *url.URL) (net.Conn, error) {
+	if proxyURL == nil {
+		if strings.EqualFold(target.Scheme, "https") && t.transport.DialTLSContext != nil {
+			return t.transport.DialTLSContext(ctx, "tcp", canonicalAddr(target))
+		}
+		return t.dialScheme(ctx, target.Scheme, target.Hostname(), canonicalAddr(target))
+	}
+
+	conn, err := t.dialScheme(ctx, proxyURL.Scheme, proxyURL.Hostname(), canonicalAddr(proxyURL))
+	if err != nil || !strings.EqualFold(target.Scheme, "https") {
+		return conn, err
+	}
+
+	// the https request is tunneled through the proxy by CONNECT
+	if err = connectTunnel(ctx, conn, canonicalAddr(target), proxyURL); err != nil {
+		// nolint: errcheck
+		conn.Close()
+		return nil, err
+	}
+	return t.handshake(ctx, conn, target.Hostname())
+}
+
+// dialScheme dials addr by the dial function of the transport, and handshakes by TLS for https
+func (t *http10Transport) dialScheme(ctx context.Context, scheme, serverName, addr string) (net.Conn, error) {
+	conn, err := dialContext(t.transport)(ctx, "tcp", addr)
+	if err != nil || !strings.EqualFold(scheme, "https") {
+		return conn, err
+	}
+	return t.handshake(ctx, conn, serverName)
+}
+
+// handshake runs the TLS handshake on conn by the TLS config of the transport within ctx
+func (t *http10Transport) handshake(ctx context.Context, conn net.Conn, serverName string) (net.Conn, error) {
+	config := &tls.Config{}
+	if t.transport.TLSClientConfig != nil {
+		config = t.transport.TLSClientConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	// HTTP/1.0 is never negotiated by ALPN
+	config.NextProtos = nil
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		// nolint: errcheck
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connectTunnel establishes the tunnel to addr through the proxy connection by the CONNECT request
+func connectTunnel(ctx context.Context, conn net.Conn, addr string, proxyURL *url.URL) error {
+	connectReq := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if auth := proxyAuthorization(proxyURL); auth != "" {
+		connectReq.Header.Set("Proxy-Authorization", auth)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// nolint: errcheck
+		conn.SetDeadline(deadline)
+	}
+	if err := connectReq.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	// nolint: errcheck
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy CONNECT %v: %v", addr, resp.Status)
+	}
+	return nil
+}
+
+// connBody is the response body closing the connection on close
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+// Close implements the io.Closer interface
+func (b *connBody) Close() error {
+	err := b.ReadCloser.Close()
+	if connErr := b.conn.Close(); err == nil {
+		err = connErr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package httpclient
+
+import (
+	"errors"
+	"regexp"
+
+	"golang.org/x/net/http2"
+)
+
+// http2ErrCodeRegexp matches the HTTP/2 error code in the error message
+var http2ErrCodeRegexp = regexp.MustCompile(`\b(NO_ERROR|PROTOCOL_ERROR|INTERNAL_ERROR|FLOW_CONTROL_ERROR|SETTINGS_TIMEOUT|` +
+	`STREAM_CLOSED|FRAME_SIZE_ERROR|REFUSED_STREAM|CANCEL|COMPRESSION_ERROR|CONNECT_ERROR|ENHANCE_YOUR_CALM|` +
+	`INADEQUATE_SECURITY|HTTP_1_1_REQUIRED)\b`)
+
+// HTTP2StreamError is the HTTP/2 stream or connection error with the error code, e.g. `REFUSED_STREAM`
+type HTTP2StreamError struct {
+	Code string
+	Err  error
+}
+
+// Error implements the error interface
+func (e *HTTP2StreamError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *HTTP2StreamError) Unwrap() error {
+	return e.Err
+}
+
+// asHTTP2StreamError returns the HTTP/2 error in the error chain, or parsed from the error message of the bundled
+// HTTP/2 implementation of net/http, whose error types are unexported. It returns nil if not an HTTP/2 error.
+func asHTTP2StreamError(err error) *HTTP2StreamError {
+	var h2Err *HTTP2StreamError
+	if errors.As(err, &h2Err) {
+		return h2Err
+	}
+
+	var streamErr http2.StreamError
+	if errors.As(err, &streamErr) {
+		return &HTTP2StreamError{Code: streamErr.Code.String(), Err: err}
+	}
+
+	var goAwayErr http2.GoAwayError
+	if errors.As(err, &goAwayErr) {
+		return &HTTP2StreamError{Code: goAwayErr.ErrCode.String(), Err: err}
+	}
+
+	if match := http2ErrCodeRegexp.FindStringSubmatch(err.Error()); match != nil {
+		return &HTTP2StreamError{Code: match[1], Err: err}
+	}
+	return nil
+}
+
+// wrapHTTP2Error wraps the HTTP/2 error as *HTTP2StreamError, other errors are returned as is
+func wrapHTTP2Error(err error) error {
+	if h2Err := asHTTP2StreamError(err); h2Err != nil {
+		return h2Err
+	}
+	return err
+}
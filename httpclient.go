@@ -3,16 +3,21 @@ package httpclient
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"context"
 
+	"github.com/eapache/go-resiliency/breaker"
 	"github.com/eapache/go-resiliency/retrier"
 	"github.com/std0d9k81/log"
 )
@@ -25,9 +30,52 @@ var (
 // Client is the http client handle
 type Client struct {
 	*http.Client
-	retrier      *retrier.Retrier
-	reqOpts      []RequestOption
-	debugTraffic bool
+	retrier           *retrier.Retrier
+	backoff           BackoffStrategy
+	classifier        retrier.Classifier
+	reqOpts           []RequestOption
+	debugTraffic      bool
+	jsonSchema        *jsonSchemaValidator
+	redirectTrace     func(hops []RedirectHop)
+	deadlineHeader    string
+	deadlineFormatter DeadlineFormatter
+	retryGroup        *flightGroup
+	flightGroup       *flightGroup
+	jsonMarshal       JSONMarshalFunc
+	jsonUnmarshal     JSONUnmarshalFunc
+	respValidators    []ResponseValidator
+	statusValidator   func(code int) bool
+	respCache         *responseCache
+	sleep             SleepFunc
+	startupProbes     []func(ctx context.Context, client *Client) error
+	optErr            error
+	maxResponseSize   int64
+	binaryLogSafe     bool
+	autoCharset       bool
+	testResponses     *testResponses
+	dupHeaderPolicy   DuplicateHeaderPolicy
+	onRequest         []func(req *http.Request)
+	onResponse        []func(resp *http.Response, elapsed time.Duration)
+	breaker           *breaker.Breaker
+	countRetries      bool
+	requestID         func() string
+	retryMethods      map[string]bool
+	warmupURL         string
+	warmupConns       int
+	pipeline          []ResponseStage
+	maxRetryElapsed   time.Duration
+	perAttemptTimeout time.Duration
+	onRetry           []func(attempt int, err error, nextBackoff time.Duration)
+	quota             *requestQuota
+	concurrency       concurrencyLimit
+	connLifetime      bool
+	incomplete        func(result interface{}) bool
+	logKeys           []interface{}
+	contentTypeCheck  bool
+	strictOptions     bool
+	optConflicts      []string
+	redirectOption    string
+	httpVersionOption string
 }
 
 // New creates a new http client with specified client options
@@ -39,9 +87,59 @@ func New(opts ...ClientOption) *Client {
 	for _, opt := range opts {
 		opt(client)
 	}
+	client.defaultTimeout()
+	if client.warmupConns > 0 {
+		client.warmup(client.warmupURL, client.warmupConns)
+	}
 	return client
 }
 
+// NewWithError creates a new http client with specified client options like New,
+// and returns the error if any client option fails, the options conflict in the strict mode, or any startup probe fails.
+func NewWithError(ctx context.Context, opts ...ClientOption) (*Client, error) {
+	client := New(opts...)
+	if client.optErr != nil {
+		return nil, client.optErr
+	}
+	if err := client.conflictErr(); err != nil {
+		return nil, err
+	}
+	for _, probe := range client.startupProbes {
+		if err := probe(ctx, client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Clone returns a copy of the client with the new client options applied, the original client is not mutated
+func (client *Client) Clone(opts ...ClientOption) *Client {
+	clone := *client
+	httpClient := *client.Client
+	clone.Client = &httpClient
+	clone.reqOpts = client.reqOpts[:len(client.reqOpts):len(client.reqOpts)]
+	clone.optConflicts = client.optConflicts[:len(client.optConflicts):len(client.optConflicts)]
+	if client.retryGroup != nil {
+		clone.retryGroup = newFlightGroup()
+	}
+	if client.flightGroup != nil {
+		clone.flightGroup = newFlightGroup()
+	}
+	for _, opt := range opts {
+		opt(&clone)
+	}
+	clone.defaultTimeout()
+	return &clone
+}
+
+// defaultTimeout sets the timeout to DefaultTimeout if not specified, once the client is created,
+// since the client is used concurrently afterwards
+func (client *Client) defaultTimeout() {
+	if client.Timeout == 0 {
+		client.Timeout = DefaultTimeout
+	}
+}
+
 // NewJSON return a JSON client wrapper
 func (client *Client) NewJSON() *JSONClient {
 	return &JSONClient{client}
@@ -52,19 +150,42 @@ func (client *Client) NewXML() *XMLClient {
 	return &XMLClient{client}
 }
 
+// NewYAML return a YAML client wrapper
+func (client *Client) NewYAML() *YAMLClient {
+	return &YAMLClient{client}
+}
+
+// NewMsgpack return a MessagePack client wrapper
+func (client *Client) NewMsgpack() *MsgpackClient {
+	return &MsgpackClient{client}
+}
+
 // SetDefaultReqOpts set the default request options, applied before each request.
 func (client *Client) SetDefaultReqOpts(reqOpts ...RequestOption) {
 	client.reqOpts = reqOpts[:len(reqOpts):len(reqOpts)]
 }
 
-// SetRetry set the retry backoff
+// SetRetry set the retry backoff, the backoff sleeps are interrupted once the context is done.
+// Only the idempotent methods GET, HEAD, OPTIONS, PUT and DELETE are retried by default, or the requests with
+// the `Idempotency-Key` header, see WithRetryMethods.
 func (client *Client) SetRetry(backoff []time.Duration) {
-	client.retrier = retrier.New(backoff, DefaultRetryClassifier)
+	client.SetBackoffStrategy(BackoffSchedule(backoff[:len(backoff):len(backoff)]))
 }
 
-// SetRetrier set the retrier
+// SetBackoffStrategy set the retry backoff strategy deciding the backoff of each retry, e.g. by the `Retry-After` header.
+// The backoff sleeps are interrupted once the context is done, and the retried methods are the same as SetRetry.
+func (client *Client) SetBackoffStrategy(backoff BackoffStrategy) {
+	client.retrier = nil
+	client.backoff = backoff
+	client.classifier = DefaultRetryClassifier
+}
+
+// SetRetrier set the retrier, which checks the context between attempts, but can't interrupt the backoff sleeps.
+// The retried methods are decided by the classifier of the retrier, regardless of WithRetryMethods.
 func (client *Client) SetRetrier(r *retrier.Retrier) {
 	client.retrier = r
+	client.backoff = nil
+	client.classifier = nil
 }
 
 // Options sends the OPTIONS request
@@ -72,16 +193,52 @@ func (client *Client) Options(ctx context.Context, url, body string, reqOpts ...
 	return client.Do(ctx, "OPTIONS", url, body, reqOpts...)
 }
 
+// OptionsAllowed sends the OPTIONS request, and returns the methods listed by the `Allow` response header
+func (client *Client) OptionsAllowed(ctx context.Context, url string, reqOpts ...RequestOption) (methods []string, err error) {
+	resp, err := client.DoResponse(ctx, "OPTIONS", url, "", reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range resp.Header.Values("Allow") {
+		for _, method := range strings.Split(value, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				methods = append(methods, method)
+			}
+		}
+	}
+	return methods, nil
+}
+
 // Head sends the HEAD request
 func (client *Client) Head(ctx context.Context, url, body string, reqOpts ...RequestOption) (result string, err error) {
 	return client.Do(ctx, "HEAD", url, body, reqOpts...)
 }
 
+// HeadHeaders sends the HEAD request, and returns the response headers, e.g. to check the `Content-Length` or
+// `Last-Modified` of the resource without downloading it
+func (client *Client) HeadHeaders(ctx context.Context, url string, reqOpts ...RequestOption) (http.Header, error) {
+	resp, err := client.DoResponse(ctx, "HEAD", url, "", reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Header, nil
+}
+
 // Get sends the GET request
 func (client *Client) Get(ctx context.Context, url, body string, reqOpts ...RequestOption) (result string, err error) {
 	return client.Do(ctx, "GET", url, body, reqOpts...)
 }
 
+// GetWithCookies sends the GET request, and returns the response body with the cookies set by the response
+func (client *Client) GetWithCookies(ctx context.Context, url, body string, reqOpts ...RequestOption) (result string, cookies []*http.Cookie, err error) {
+	resp, err := client.DoResponse(ctx, "GET", url, body, reqOpts...)
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.Result, resp.SetCookies, nil
+}
+
 // Post sends the POST request
 func (client *Client) Post(ctx context.Context, url, body string, reqOpts ...RequestOption) (result string, err error) {
 	return client.Do(ctx, "POST", url, body, reqOpts...)
@@ -104,154 +261,388 @@ func (client *Client) Delete(ctx context.Context, url, body string, reqOpts ...R
 
 // Do sends a custom METHOD request
 func (client *Client) Do(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (result string, err error) {
-	if client.retrier == nil {
-		return client.do(ctx, method, url, body, reqOpts...)
+	var resp *Response
+	if resp, err = client.DoResponse(ctx, method, url, body, reqOpts...); err != nil {
+		return "", err
 	}
+	return resp.Result, nil
+}
 
-	err = client.retrier.Run(func() error {
-		if result, err = client.do(ctx, method, url, body, reqOpts...); err != nil {
-			return err
-		}
-		return nil
-	})
+// DoResponse sends a custom METHOD request, and returns the response with the body already read.
+// The retry policy overridden by ContextWithRetry or ContextWithoutRetry takes precedence over the client one.
+// The circuit breaker set by WithCircuitBreaker is fed by the final outcome or each attempt, see WithFailureCounting.
+// The response is also returned along with the *HTTPError when the status code is not successful,
+// or *ProblemError for the `application/problem+json` body, or along with the error of the response validator.
+func (client *Client) DoResponse(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (resp *Response, err error) {
+	if client.flightGroup != nil && idempotentMethods[method] {
+		return client.doShared(ctx, method, url, body, reqOpts...)
+	}
+	return client.doResponse(ctx, method, url, body, reqOpts...)
+}
 
-	return result, err
+// doResponse sends the request through the circuit breaker if set
+func (client *Client) doResponse(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (resp *Response, err error) {
+	if client.breaker == nil || client.countRetries {
+		return client.doRetry(ctx, method, url, body, reqOpts...)
+	}
+
+	err = client.breaker.Run(func() (runErr error) {
+		resp, runErr = client.doRetry(ctx, method, url, body, reqOpts...)
+		return runErr
+	})
+	return resp, err
 }
 
-// DownloadFile download file from url
-func (client *Client) DownloadFile(ctx context.Context, url, outFile string, reqOpts ...RequestOption) (err error) {
-	var (
-		req    *http.Request
-		resp   *http.Response
-		method = "GET"
-	)
+// doRetry sends the request with the retry policy
+func (client *Client) doRetry(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (resp *Response, err error) {
+	if client.perAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.Timeout)
+		defer cancel()
+	}
+
+	classifier := client.classifier
+	backoff, overridden := retryFromContext(ctx)
+	if !overridden {
+		backoff = client.backoff
+	} else if classifier == nil {
+		classifier = DefaultRetryClassifier
+	}
+
+	if client.retrier == nil && classifier == nil {
+		return client.attempt(ctx, method, url, body, reqOpts...)
+	}
 
-	if req, err = http.NewRequest(method, url, nil); err != nil {
+	attempt := 0
+	work := func() error {
+		attempt++
+		attemptCtx := context.WithValue(ctx, attemptKey{}, attempt)
+		if attempt > 1 && client.retryGroup != nil {
+			resp, err = client.doCoalesced(attemptCtx, method, url, body, reqOpts...)
+		} else {
+			resp, err = client.attempt(attemptCtx, method, url, body, reqOpts...)
+		}
 		return err
 	}
 
-	reqOpts = append(client.reqOpts, reqOpts...)
+	if overridden || client.retrier == nil {
+		allowed := true
+		ctx = context.WithValue(ctx, retryGateKey{}, &allowed)
+		err = client.retry(ctx, backoff, gatedClassifier{classifier, &allowed}, work)
+	} else {
+		err = client.retrier.Run(func() error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return work()
+		})
+	}
+
+	return resp, err
+}
+
+// prepareRequest applies the request options to the request, then sets the request id, propagates the deadline
+// and signs the final request
+func (client *Client) prepareRequest(ctx context.Context, req *http.Request, reqOpts []RequestOption) (context.Context, error) {
+	var err error
+
+	if ctx, err = client.applyRequestOptions(ctx, req, reqOpts); err != nil {
+		return ctx, err
+	}
+
+	if client.requestID != nil && req.Header.Get(RequestIDHeader) == "" {
+		req.Header.Set(RequestIDHeader, client.requestID())
+	}
+
+	if client.deadlineHeader != "" {
+		propagateDeadline(ctx, req, client.deadlineHeader, client.deadlineFormatter)
+	}
+
+	if err = signRequest(ctx, req); err != nil {
+		return ctx, err
+	}
+	return ctx, nil
+}
+
+// applyRequestOptions applies the default and the specified request options to the request
+func (client *Client) applyRequestOptions(ctx context.Context, req *http.Request, reqOpts []RequestOption) (context.Context, error) {
+	var err error
+
+	reqOpts = append(client.reqOpts[:len(client.reqOpts):len(client.reqOpts)], reqOpts...)
+
+	// GetBody re-sends the body on 307/308 redirects, it must not re-send the stale body replaced by the options
+	body, getBody := req.Body, req.GetBody
+	req.GetBody = nil
 
 	for _, reqOpt := range reqOpts {
 		if ctx, err = reqOpt(ctx, req); err != nil {
-			return err
+			return ctx, err
 		}
 	}
 
-	if client.Timeout == 0 {
-		client.Timeout = DefaultTimeout
+	if req.GetBody == nil && req.Body == body {
+		req.GetBody = getBody
 	}
+	return ctx, nil
+}
 
-	ctx = log.WithContext(ctx,
-		"method", method,
-		"url", req.URL.String(),
-		"out_file", outFile,
-	)
+// isSuccess checks whether the status code is successful, which is in range [200,300) by default
+func (client *Client) isSuccess(code int) bool {
+	if client.statusValidator != nil {
+		return client.statusValidator(code)
+	}
+	return code >= 200 && code < 300
+}
+
+// send sends the request by the underlying http client, the request and response hooks are invoked around it
+func (client *Client) send(req *http.Request) (resp *http.Response, err error) {
+	// the quota is taken once the request is about to be sent, the request giving up on the concurrency slot is not counted
+	if err = client.concurrency.acquire(req.Context()); err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+	if err = client.quota.take(); err != nil {
+		client.concurrency.release()
+		closeRequestBody(req)
+		return nil, err
+	}
 
+	for _, fn := range client.onRequest {
+		fn(req)
+	}
+
+	req = client.traceConnLifetime(req)
 	begin := time.Now()
-	resp, err = client.Client.Do(req)
+	if client.testResponses != nil {
+		resp, err = client.testResponses.respond(req)
+	} else if resp, err = client.sendTraced(req); err == nil &&
+		resp.StatusCode == http.StatusExpectationFailed && req.Header.Get("Expect") != "" {
+		resp, err = client.retryWithoutExpect(req, resp)
+	}
 	if err != nil {
-		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
-		return err
+		client.concurrency.release()
+		return nil, wrapHTTP2Error(err)
+	}
+	if client.concurrency != nil {
+		resp.Body = &releasingBody{ReadCloser: resp.Body, sem: client.concurrency}
 	}
-	// nolint: errcheck
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		err = &HTTPError{resp.StatusCode, resp.Status}
-		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
-		return err
+	client.dedupHeaders(req.Context(), resp.Header)
+
+	for _, fn := range client.onResponse {
+		fn(resp, time.Since(begin))
 	}
+	return resp, nil
+}
 
-	// open file
-	out, err := os.Create(outFile)
-	if err != nil {
-		log.Error(ctx, "create download file", "error", err, "proc_time", time.Since(begin))
-		return err
+// closeRequestBody closes the body of the request not sent, as the http client does, e.g. to close the files
+// opened by SetMultipart
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		// nolint: errcheck
+		req.Body.Close()
+	}
+}
+
+// decodeBody returns the reader decoding the body by the content encoding,
+// for the case server send gzipped data even if client not sending "Accept-Encoding: gzip"
+func decodeBody(body io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch contentEncoding {
+	case "gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		return zlib.NewReader(body)
+	default:
+		return ioutil.NopCloser(body), nil
 	}
-	defer out.Close()
+}
 
-	written, err := io.Copy(out, resp.Body)
-	if err != nil {
-		log.Error(ctx, "copy response data to download file", "error", err, "proc_time", time.Since(begin))
-		return err
+// maxBinaryLogSize is the max size of the binary data summarized in the log
+const maxBinaryLogSize = 64
+
+// withLogKeys adds the values of the context keys set by WithContextLogKeys as the log fields, the missing ones are skipped
+func (client *Client) withLogKeys(ctx context.Context) context.Context {
+	for _, key := range client.logKeys {
+		if value := ctx.Value(key); value != nil {
+			ctx = log.WithContext(ctx, fmt.Sprint(key), value)
+		}
 	}
+	return ctx
+}
 
-	log.Debug(ctx, "request success", "file_size", written, "proc_time", time.Since(begin))
+// logText returns the text to be logged, the binary data is summarized by its length and base64 prefix if WithBinaryLogSafe
+func (client *Client) logText(text string) string {
+	if !client.binaryLogSafe || isPrintable(text) {
+		return text
+	}
 
-	return nil
+	data := text
+	if len(data) > maxBinaryLogSize {
+		data = data[:maxBinaryLogSize]
+	}
+	return fmt.Sprintf("<binary %d bytes, base64: %s>", len(text), base64.StdEncoding.EncodeToString([]byte(data)))
+}
 
+// isPrintable checks whether the text is valid UTF-8 consisting of the printable characters and spaces
+func isPrintable(text string) bool {
+	if !utf8.ValidString(text) {
+		return false
+	}
+	for _, r := range text {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// markDecoded updates the response metadata to match the decoded body of the specified length,
+// the `Content-Encoding` header is removed and the `Content-Length` is recomputed like the transparent
+// decompression of net/http, so callers won't preallocate by the compressed size.
+func markDecoded(resp *http.Response, length int) {
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip", "deflate":
+	default:
+		return
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(length))
+	resp.ContentLength = int64(length)
+	resp.Uncompressed = true
 }
 
 // do the internal request sending implementation
-func (client *Client) do(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (result string, err error) {
+func (client *Client) do(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (result *Response, err error) {
 	var (
 		req      *http.Request
 		resp     *http.Response
 		respData []byte
+		cached   *cacheEntry
+		fresh    *Response
 	)
 
 	if req, err = http.NewRequest(method, url, strings.NewReader(body)); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	reqOpts = append(client.reqOpts, reqOpts...)
-
-	for _, reqOpt := range reqOpts {
-		if ctx, err = reqOpt(ctx, req); err != nil {
-			return "", err
-		}
+	if ctx, err = client.prepareRequest(ctx, req, reqOpts); err != nil {
+		return nil, err
 	}
+	req = req.WithContext(ctx)
 
-	if client.Timeout == 0 {
-		client.Timeout = DefaultTimeout
+	if allowed, ok := ctx.Value(retryGateKey{}).(*bool); ok {
+		*allowed = client.retryAllowed(req)
 	}
 
 	ctx = log.WithContext(ctx,
 		"method", method,
 		"url", req.URL.String(),
 	)
+	if client.requestID != nil {
+		ctx = log.WithContext(ctx, "request_id", req.Header.Get(RequestIDHeader))
+	}
+	ctx = client.withLogKeys(ctx)
 	if client.debugTraffic {
-		ctx = log.WithContext(ctx, "body", body)
+		ctx = log.WithContext(ctx, "body", client.logText(body))
 	}
 
+	// the cookies of the jar are added when sent, the request carrying them is not cached as the credentials
+	respCache := client.respCache
+	if client.Jar != nil && len(client.Jar.Cookies(req.URL)) > 0 {
+		respCache = nil
+	}
+
+	if cached, fresh = respCache.lookup(req); fresh != nil {
+		log.Debug(ctx, "response cache hit", "etag", fresh.Header.Get("ETag"))
+		return fresh, nil
+	}
+	requested := respCache.clock()
+
 	begin := time.Now()
-	resp, err = client.Client.Do(req)
+	resp, err = client.send(req)
 	if err != nil {
 		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
-		return "", err
+		return nil, err
 	}
 	// nolint: errcheck
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		err = &HTTPError{resp.StatusCode, resp.Status}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		respCache.revalidated(cached, resp, requested)
+		log.Debug(ctx, "response not modified", "etag", cached.etag, "proc_time", time.Since(begin))
+		return &Response{Response: resp, Result: cached.body, ServerTiming: ParseServerTiming(resp.Header)}, nil
+	}
+
+	if !client.isSuccess(resp.StatusCode) {
+		err = newStatusError(resp)
 		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
-		return "", err
+		return &Response{Response: resp, ServerTiming: ParseServerTiming(resp.Header)}, err
 	}
 
 	var reader io.ReadCloser
-	// for the case server send gzipped data even if client not sending "Accept-Encoding: gzip"
-	switch resp.Header.Get("Content-Encoding") {
-	case "gzip":
-		if reader, err = gzip.NewReader(resp.Body); err != nil {
-			log.Error(ctx, "create gzip reader", "error", err, "proc_time", time.Since(begin))
-			return "", err
-		}
-		defer reader.Close()
-	default:
-		reader = ioutil.NopCloser(resp.Body)
+	wire := &countingReader{Reader: resp.Body}
+	if reader, err = decodeBody(wire, resp.Header.Get("Content-Encoding")); err != nil {
+		log.Error(ctx, "create decode reader", "error", err, "proc_time", time.Since(begin))
+		return nil, err
+	}
+	// nolint: errcheck
+	defer reader.Close()
+
+	var limited io.Reader = reader
+	if client.maxResponseSize > 0 {
+		limited = io.LimitReader(reader, client.maxResponseSize+1)
 	}
 
-	if respData, err = ioutil.ReadAll(reader); err != nil {
+	if respData, err = ioutil.ReadAll(limited); err != nil {
+		log.Error(ctx, "read response body", "error", err, "read_size", len(respData), "proc_time", time.Since(begin))
+		return nil, newPartialBodyError(respData, err)
+	}
+
+	if client.maxResponseSize > 0 && int64(len(respData)) > client.maxResponseSize {
+		err = fmt.Errorf("%w: exceeds %v bytes", ErrResponseTooLarge, client.maxResponseSize)
 		log.Error(ctx, "read response body", "error", err, "proc_time", time.Since(begin))
-		return "", err
+		return nil, err
 	}
+	markDecoded(resp, len(respData))
 
-	result = string(respData)
+	if client.autoCharset {
+		if respData, err = transcodeCharset(resp, respData); err != nil {
+			log.Error(ctx, "transcode response charset", "error", err, "proc_time", time.Since(begin))
+			return nil, err
+		}
+	}
+
+	if respData, err = client.runPipeline(resp, respData); err != nil {
+		log.Error(ctx, "transform response", "error", err, "proc_time", time.Since(begin))
+		return nil, err
+	}
+
+	result = &Response{
+		Response:     resp,
+		Result:       string(respData),
+		BytesRead:    wire.n,
+		BytesDecoded: int64(len(respData)),
+		ServerTiming: ParseServerTiming(resp.Header),
+		SetCookies:   resp.Cookies(),
+	}
+
+	for _, validator := range client.respValidators {
+		if err = validator(result); err != nil {
+			log.Error(ctx, "invalid response", "error", err, "proc_time", time.Since(begin))
+			return result, err
+		}
+	}
+	if validator, ok := ctx.Value(callValidatorKey{}).(ResponseValidator); ok {
+		if err = validator(result); err != nil {
+			log.Error(ctx, "invalid response", "error", err, "proc_time", time.Since(begin))
+			return result, err
+		}
+	}
+	respCache.store(req, resp, result.Result, requested)
 
 	buf := &bytes.Buffer{}
-	for _, cookie := range resp.Cookies() {
+	for _, cookie := range result.SetCookies {
 		buf.WriteString(fmt.Sprintf("%v=%v|", cookie.Name, cookie.Value))
 	}
 
@@ -261,7 +652,7 @@ func (client *Client) do(ctx context.Context, method, url, body string, reqOpts
 
 	if client.debugTraffic {
 		log.Debug(ctx, "request success",
-			"result", result,
+			"result", client.logText(result.Result),
 			"set_cookies", buf.String(),
 			"proc_time", time.Since(begin),
 		)
@@ -1,19 +1,55 @@
 package httpclient
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/eapache/go-resiliency/breaker"
+	"github.com/eapache/go-resiliency/retrier"
 	"github.com/std0d9k81/log"
 	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/http2"
+	"golang.org/x/oauth2"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gopkg.in/yaml.v3"
 )
 
 func TestGet(t *testing.T) {
@@ -57,6 +93,173 @@ func TestPost(t *testing.T) {
 	require.Equal(t, "hello world", result)
 }
 
+func TestFormData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		fmt.Fprintf(w, "%v,%v", r.PostForm.Get("a"), r.PostForm.Get("b"))
+	}))
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	form := url.Values{}
+	form.Add("a", "1")
+	form.Add("b", "2")
+	result, err := client.Post(ctx, server.URL, "", SetFormData(form))
+	require.NoError(t, err)
+	require.Equal(t, "1,2", result)
+
+	_, err = client.Get(ctx, server.URL, "", SetFormData(form))
+	require.True(t, errors.Is(err, ErrBodyNotAllowed))
+}
+
+func TestPathParams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.EscapedPath())
+	}))
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	result, err := client.Get(ctx, server.URL+"/users/{id}/orders/{orderID}", "", SetPathParams(map[string]string{
+		"id":      "42",
+		"orderID": "a/b c",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "/users/42/orders/a%2Fb%20c", result)
+
+	_, err = client.Get(ctx, server.URL+"/users/{id}/orders/{orderId}", "", SetPathParams(map[string]string{
+		"id": "42",
+	}))
+	require.True(t, errors.Is(err, ErrUnresolvedPathParam))
+}
+
+func TestGzipBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.Copy(w, zr)
+	}))
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	body := strings.Repeat(`{"hello":"world"}`, 100)
+	result, err := client.Post(ctx, server.URL, body, SetTypeJSON(), SetGzipBody())
+	require.NoError(t, err)
+	require.Equal(t, body, result)
+}
+
+func TestForceHTTP10(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%v %v", r.Proto, string(data))
+	}))
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	result, err := client.Post(ctx, server.URL, "hello world", ForceHTTP10())
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.0 hello world", result)
+
+	result, err = client.Post(ctx, server.URL, "hello world")
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.1 hello world", result)
+
+	// the dialer and the OAuth2 transport of the client are kept
+	dials := int32(0)
+	client = New(WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}), WithOAuth2(&countingTokenSource{}))
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v %v", r.Proto, r.Header.Get("Authorization"))
+	}))
+	defer authServer.Close()
+	result, err = client.Get(ctx, authServer.URL, "", ForceHTTP10())
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.0 Bearer token-1", result)
+	require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+
+	// the request is sent to the proxy in the absolute form
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v %v", r.Proto, r.RequestURI)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+	client = New(SetTransport(&http.Transport{Proxy: http.ProxyURL(proxyURL)}))
+	result, err = client.Get(ctx, "http://example.test/path?q=1", "", ForceHTTP10())
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.0 http://example.test/path?q=1", result)
+
+	// the TLS config of the transport is used
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	defer tlsServer.Close()
+	client = New(SetTransport(tlsServer.Client().Transport.(*http.Transport).Clone()))
+	result, err = client.Get(ctx, tlsServer.URL, "", ForceHTTP10())
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.0", result)
+
+	// the https request is tunneled through the proxy by CONNECT
+	connects := int32(0)
+	tunnel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "CONNECT", r.Method)
+		atomic.AddInt32(&connects, 1)
+		upstream, err := net.Dial("tcp", r.Host)
+		require.NoError(t, err)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer tunnel.Close()
+	tunnelURL, err := url.Parse(tunnel.URL)
+	require.NoError(t, err)
+	transport := tlsServer.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(tunnelURL)
+	client = New(SetTransport(transport))
+	result, err = client.Get(ctx, tlsServer.URL, "", ForceHTTP10())
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.0", result)
+	require.Equal(t, int32(1), atomic.LoadInt32(&connects))
+
+	// the transport which can't be honored fails the request
+	client = New(SetTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("unexpected")
+	})))
+	_, err = client.Get(ctx, server.URL, "", ForceHTTP10())
+	require.True(t, errors.Is(err, ErrHTTP10Unsupported))
+}
+
+func TestSetCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, cookie := range r.Cookies() {
+			fmt.Fprintf(w, "%v=%v;", cookie.Name, cookie.Value)
+		}
+	}))
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	result, err := client.Get(ctx, server.URL, "",
+		SetCookies(&http.Cookie{Name: "a", Value: "1"}, &http.Cookie{Name: "b", Value: "2"}),
+		SetCookieValue("c", "3"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "a=1;b=2;c=3;", result)
+}
+
 func TestJSONPost(t *testing.T) {
 	type Hello struct {
 		Hello string `json:"hello"`
@@ -104,28 +307,3398 @@ func TestJSONPost(t *testing.T) {
 	require.Equal(t, "hello world", result.ErrMsg)
 }
 
-func TestLogContextFunc(t *testing.T) {
+func TestYAMLPost(t *testing.T) {
+	type Hello struct {
+		Hello string `yaml:"hello"`
+	}
+
+	type HelloResult struct {
+		ErrNo  int    `yaml:"errno"`
+		ErrMsg string `yaml:"errmsg"`
+	}
+
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.ParseForm()
-		if r.Form.Get("hello") == "world" {
-			fmt.Fprintf(w, "hello world")
-		} else {
-			fmt.Fprintf(w, "bad hello")
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		h := &Hello{}
+		err = yaml.Unmarshal(data, h)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch h.Hello {
+		case "world":
+			fmt.Fprintf(w, "errno: 0\nerrmsg: hello world\n")
+		case "empty":
+		default:
+			fmt.Fprintf(w, "errno: 1\nerrmsg: bad hello\n")
 		}
 	}))
 
 	ctx := context.TODO()
-	client := New(Timeout(time.Second*5), DisableRedirect)
-	client.SetDefaultReqOpts(func(ctx context.Context, req *http.Request) (context.Context, error) {
-		return log.WithContext(ctx, "log_method", req.Method), nil
+	client := NewYAML(Timeout(time.Second*5), DisableRedirect)
+
+	result := &HelloResult{}
+	err := client.Post(ctx, server.URL, &Hello{Hello: "world"}, result)
+	require.NoError(t, err)
+	require.Equal(t, 0, result.ErrNo)
+	require.Equal(t, "hello world", result.ErrMsg)
+
+	result = &HelloResult{}
+	err = client.Post(ctx, server.URL, &Hello{Hello: "empty"}, result)
+	require.NoError(t, err)
+	require.Equal(t, &HelloResult{}, result)
+}
+
+func TestMsgpackPost(t *testing.T) {
+	type Hello struct {
+		Hello string `msgpack:"hello"`
+	}
+
+	type HelloResult struct {
+		ErrNo  int    `msgpack:"errno"`
+		ErrMsg string `msgpack:"errmsg"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := &Hello{}
+		if err := msgpack.NewDecoder(r.Body).Decode(h); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch h.Hello {
+		case "world":
+			msgpack.NewEncoder(w).Encode(&HelloResult{ErrNo: 0, ErrMsg: "hello world"})
+		case "empty":
+		default:
+			msgpack.NewEncoder(w).Encode(&HelloResult{ErrNo: 1, ErrMsg: "bad hello"})
+		}
+	}))
+
+	ctx := context.TODO()
+	client := NewMsgpack(Timeout(time.Second*5), DisableRedirect)
+
+	result := &HelloResult{}
+	err := client.Post(ctx, server.URL, &Hello{Hello: "world"}, result)
+	require.NoError(t, err)
+	require.Equal(t, 0, result.ErrNo)
+	require.Equal(t, "hello world", result.ErrMsg)
+
+	result = &HelloResult{}
+	err = client.Post(ctx, server.URL, &Hello{Hello: "empty"}, result)
+	require.NoError(t, err)
+	require.Equal(t, &HelloResult{}, result)
+}
+
+func TestDecodeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html>bad gateway</p>")
+	}))
+
+	ctx := context.TODO()
+	result := map[string]interface{}{}
+
+	err := NewJSON(Timeout(time.Second*5)).Get(ctx, server.URL, nil, &result)
+	require.IsType(t, &DecodeError{}, err)
+	require.Equal(t, "<html>bad gateway</p>", err.(*DecodeError).Body)
+	require.Equal(t, "text/html", err.(*DecodeError).ContentType)
+	require.Equal(t, http.StatusOK, err.(*DecodeError).StatusCode)
+
+	err = NewXML(Timeout(time.Second*5)).Get(ctx, server.URL, nil, &struct{ A int }{})
+	require.IsType(t, &DecodeError{}, err)
+	require.Equal(t, "<html>bad gateway</p>", err.(*DecodeError).Body)
+}
+
+func TestJSONErrorBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "<html>internal error</html>")
+	}))
+
+	ctx := context.TODO()
+	client := NewJSON(Timeout(time.Second * 5))
+
+	result := map[string]interface{}{}
+	err := client.Get(ctx, server.URL, nil, &result)
+	require.IsType(t, &HTTPError{}, err)
+	require.Equal(t, http.StatusInternalServerError, err.(*HTTPError).StatusCode)
+	require.Equal(t, "<html>internal error</html>", err.(*HTTPError).Body)
+	require.Empty(t, result)
+}
+
+func TestJSONCodec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+
+	var marshaled, unmarshaled int
+	marshal := func(v interface{}) ([]byte, error) {
+		marshaled++
+		return json.Marshal(v)
+	}
+	unmarshal := func(data []byte, v interface{}) error {
+		unmarshaled++
+		return json.Unmarshal(data, v)
+	}
+
+	ctx := context.TODO()
+	client := NewJSON(Timeout(time.Second*5), WithJSONCodec(marshal, unmarshal))
+
+	result := map[string]string{}
+	err := client.Post(ctx, server.URL, map[string]string{"hello": "world"}, &result)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"hello": "world"}, result)
+	require.Equal(t, 1, marshaled)
+	require.Equal(t, 1, unmarshaled)
+}
+
+func TestTypedJSON(t *testing.T) {
+	type Hello struct {
+		Hello string `json:"hello"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fmt.Fprintf(w, `[{"hello":"a"},{"hello":"b"}]`)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	hello, err := PostJSON[*Hello, Hello](ctx, client, server.URL, &Hello{Hello: "world"})
+	require.NoError(t, err)
+	require.Equal(t, Hello{Hello: "world"}, hello)
+
+	// the string body is marshaled as the JSON string rather than sent as is
+	echo, err := PostJSON[string, string](ctx, client, server.URL, "hello")
+	require.NoError(t, err)
+	require.Equal(t, "hello", echo)
+
+	raw, err := PostJSON[string, json.RawMessage](ctx, client, server.URL, `{"a":1}`)
+	require.NoError(t, err)
+	require.Equal(t, `"{\"a\":1}"`, string(raw))
+
+	hellos, err := GetJSON[[]Hello](ctx, client, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, []Hello{{Hello: "a"}, {Hello: "b"}}, hellos)
+}
+
+func TestRetryIfIncomplete(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			fmt.Fprint(w, `{"id":1}`)
+		case 2:
+			fmt.Fprint(w, `{"id":1,"na`)
+		default:
+			fmt.Fprint(w, `{"id":1,"name":"alice"}`)
+		}
+	}))
+
+	type user struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	ctx := context.TODO()
+	client := NewJSON(Timeout(time.Second*5), RetryIfIncomplete(func(result interface{}) bool {
+		return result.(*user).Name != ""
+	}))
+	client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+
+	var result user
+	require.NoError(t, client.Get(ctx, server.URL, nil, &result))
+	require.Equal(t, user{ID: 1, Name: "alice"}, result)
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	atomic.StoreInt32(&attempts, 0)
+	client.SetRetry(nil)
+	result = user{}
+	err := client.Get(ctx, server.URL, nil, &result)
+	require.True(t, errors.Is(err, ErrIncompleteResponse))
+	require.Equal(t, user{}, result)
+
+	var decodeErr *DecodeError
+	err = client.Get(ctx, server.URL, nil, &result)
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, user{}, result)
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestPostChunked(t *testing.T) {
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var items []int
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&items))
+		sizes = append(sizes, len(items))
+		fmt.Fprintf(w, `{"accepted":%d,"first":%d}`, len(items), items[0])
+	}))
+
+	items := make([]int, 250)
+	for i := range items {
+		items[i] = i
+	}
+
+	ctx := context.TODO()
+	client := NewJSON(Timeout(time.Second * 5))
+
+	var results []interface{}
+	err := client.PostChunked(ctx, server.URL, items, 100, func(result interface{}) error {
+		results = append(results, result)
+		return nil
 	})
+	require.NoError(t, err)
+	require.Equal(t, []int{100, 100, 50}, sizes)
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"accepted": float64(100), "first": float64(0)},
+		map[string]interface{}{"accepted": float64(100), "first": float64(100)},
+		map[string]interface{}{"accepted": float64(50), "first": float64(200)},
+	}, results)
 
-	query := url.Values{}
-	query.Add("hello", "world")
+	sizes = nil
+	stop := errors.New("stop")
+	err = client.PostChunked(ctx, server.URL, items, 100, func(result interface{}) error {
+		return stop
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, []int{100}, sizes)
 
-	result, err := client.Get(ctx, server.URL, "", SetQuery(query))
+	require.Error(t, client.PostChunked(ctx, server.URL, items, 0, nil))
+	require.Error(t, client.PostChunked(ctx, server.URL, "items", 10, nil))
+}
+
+func TestContentTypeCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<html><body>Bad Gateway</body></html>")
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json")
+			fmt.Fprint(w, `{"title":"ok"}`)
+		case "/xml":
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprint(w, "<user><name>alice</name></user>")
+		case "/empty":
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	ctx := context.TODO()
+	client := NewJSON(Timeout(time.Second*5), WithContentTypeCheck())
+
+	var result map[string]interface{}
+	err := client.Get(ctx, server.URL+"/html", nil, &result)
+	var ctErr *UnexpectedContentTypeError
+	require.True(t, errors.As(err, &ctErr))
+	require.True(t, errors.Is(err, ErrUnexpectedContentType))
+	require.Equal(t, "text/html; charset=utf-8", ctErr.ContentType)
+	require.Equal(t, "<html><body>Bad Gateway</body></html>", ctErr.Body)
+
+	require.NoError(t, client.Get(ctx, server.URL+"/problem", nil, &result))
+	require.Equal(t, "ok", result["title"])
+	require.NoError(t, client.Get(ctx, server.URL+"/empty", nil, &result))
+
+	var decodeErr *DecodeError
+	err = NewJSON(Timeout(time.Second*5)).Get(ctx, server.URL+"/html", nil, &result)
+	require.True(t, errors.As(err, &decodeErr))
+
+	xmlClient := NewXML(Timeout(time.Second*5), WithContentTypeCheck())
+	var user struct {
+		Name string `xml:"name"`
+	}
+	require.NoError(t, xmlClient.Get(ctx, server.URL+"/xml", nil, &user))
+	require.Equal(t, "alice", user.Name)
+	err = xmlClient.Get(ctx, server.URL+"/problem", nil, &user)
+	require.True(t, errors.Is(err, ErrUnexpectedContentType))
+}
+
+func TestNoContentResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(r.URL.Query().Get("code"))
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(code)
+	}))
+
+	type user struct {
+		Name string `json:"name" xml:"name"`
+	}
+
+	ctx := context.TODO()
+	jsonClient := NewJSON(Timeout(time.Second*5), WithContentTypeCheck(), WithStatusValidator(func(code int) bool {
+		return code < 400
+	}), RetryIfIncomplete(func(result interface{}) bool {
+		return result.(*user).Name != ""
+	}))
+	xmlClient := NewXML(Timeout(time.Second*5), WithContentTypeCheck(), WithStatusValidator(func(code int) bool {
+		return code < 400
+	}))
+
+	for _, code := range []int{http.StatusNoContent, http.StatusResetContent, http.StatusNotModified} {
+		url := fmt.Sprintf("%v/?code=%d", server.URL, code)
+
+		var result user
+		require.NoError(t, jsonClient.Get(ctx, url, nil, &result))
+		require.Equal(t, user{}, result)
+		require.NoError(t, xmlClient.Get(ctx, url, nil, &result))
+		require.Equal(t, user{}, result)
+
+		result = user{Name: "kept"}
+		require.NoError(t, jsonClient.Delete(ctx, url, nil, &result))
+		require.Equal(t, user{Name: "kept"}, result)
+	}
+}
+
+func TestJSONSchemaValidation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"errno":"0"}`)
+	}))
+
+	schema := []byte(`{
+		"type": "object",
+		"properties": {
+			"errno": {"type": "integer"},
+			"errmsg": {"type": "string"}
+		},
+		"required": ["errno", "errmsg"]
+	}`)
+
+	ctx := context.TODO()
+	client := NewJSON(Timeout(time.Second*5), WithJSONSchemaValidation(schema))
+
+	result := map[string]interface{}{}
+	err := client.Get(ctx, server.URL, nil, &result)
+	require.IsType(t, &SchemaValidationError{}, err)
+
+	paths := []string{}
+	for _, v := range err.(*SchemaValidationError).Violations {
+		paths = append(paths, v.Path)
+	}
+	require.ElementsMatch(t, []string{"(root)", "errno"}, paths)
+	require.Empty(t, result)
+}
+
+func TestRedirectTrace(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusMovedPermanently))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello world")
+	})
+	server := httptest.NewServer(mux)
+
+	var hops []RedirectHop
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithRedirectTrace(func(h []RedirectHop) {
+		hops = h
+	}))
+
+	result, err := client.Get(ctx, server.URL+"/a", "")
 	require.NoError(t, err)
 	require.Equal(t, "hello world", result)
+	require.Equal(t, []RedirectHop{
+		{From: server.URL + "/a", To: server.URL + "/b", StatusCode: http.StatusFound},
+		{From: server.URL + "/b", To: server.URL + "/c", StatusCode: http.StatusMovedPermanently},
+	}, hops)
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Request-Timeout"))
+	}))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+	client := New(Timeout(time.Second*5), WithDeadlinePropagation("X-Request-Timeout", nil))
+
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+
+	remaining, err := strconv.Atoi(result)
+	require.NoError(t, err)
+	require.True(t, remaining > 1000 && remaining <= 2000, "remaining: %v", remaining)
+
+	result, err = client.Get(context.TODO(), server.URL, "")
+	require.NoError(t, err)
+	require.Empty(t, result)
+
+	require.Equal(t, "1500000u", FormatGRPCTimeout(1500*time.Millisecond))
+	require.Equal(t, "100000S", FormatGRPCTimeout(100000*time.Second))
+}
+
+func TestDownloadProgress(t *testing.T) {
+	data := strings.Repeat("hello world", 10000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		fmt.Fprint(w, data)
+	}))
+
+	outFile := filepath.Join(t.TempDir(), "out")
+
+	var calls, lastWritten, lastTotal int64
+	progress := func(written, total int64) {
+		calls++
+		lastWritten, lastTotal = written, total
+	}
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	err := client.DownloadFile(ctx, server.URL, outFile, WithProgress(progress))
+	require.NoError(t, err)
+	require.True(t, calls > 1)
+	require.Equal(t, int64(len(data)), lastWritten)
+	require.Equal(t, int64(len(data)), lastTotal)
+
+	content, err := ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, data, string(content))
+}
+
+func TestRetryCoalescing(t *testing.T) {
+	hits := map[string]*int32{"a": new(int32), "b": new(int32)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		atomic.AddInt32(hits[token], 1)
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, "busy "+token)
+	}))
+	defer server.Close()
+
+	const callers, attempts = 5, 3
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithRetryCoalescing(), WithResponseValidator(func(resp *Response) error {
+		return Retriable(errors.New(resp.Result))
+	}))
+	client.SetRetry([]time.Duration{10 * time.Millisecond, 10 * time.Millisecond})
+
+	var wg sync.WaitGroup
+	for _, token := range []string{"a", "b"} {
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				// the retries of the callers with different credentials are never merged
+				_, err := client.Get(ctx, server.URL, "", SetHeader("Authorization", token))
+				require.EqualError(t, err, "busy "+token)
+			}(token)
+		}
+	}
+	wg.Wait()
+
+	for token, n := range hits {
+		require.True(t, atomic.LoadInt32(n) < callers*attempts, "token: %v, hits: %v", token, *n)
+	}
+}
+
+func TestDecodedContentLength(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		fmt.Fprint(gw, strings.Repeat("hello world ", 100))
+		gw.Close()
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	}))
+
+	client := New(Timeout(time.Second * 5))
+	resp, err := client.DoResponse(context.TODO(), "GET", server.URL, "", SetHeader("Accept-Encoding", "gzip"))
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("hello world ", 100), resp.Result)
+	require.Equal(t, int64(len(resp.Result)), resp.ContentLength)
+	require.Equal(t, strconv.Itoa(len(resp.Result)), resp.Header.Get("Content-Length"))
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+	require.True(t, resp.Uncompressed)
+	require.True(t, resp.BytesRead < resp.BytesDecoded, "read: %v, decoded: %v", resp.BytesRead, resp.BytesDecoded)
+	require.Equal(t, int64(len(resp.Result)), resp.BytesDecoded)
+}
+
+func TestResponseCache(t *testing.T) {
+	var hits, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/nostore" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "config")
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithResponseCache(10))
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Get(ctx, server.URL, "")
+		require.NoError(t, err)
+		require.Equal(t, "config", result)
+	}
+	require.Equal(t, 3, hits)
+	require.Equal(t, 2, notModified)
+
+	notModified = 0
+	for i := 0; i < 2; i++ {
+		result, err := client.Get(ctx, server.URL+"/nostore", "")
+		require.NoError(t, err)
+		require.Equal(t, "config", result)
+	}
+	require.Equal(t, 0, notModified)
+}
+
+func TestResponseCacheCredentials(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/vary":
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		fmt.Fprint(w, r.URL.Path+" "+r.Header.Get("Authorization")+r.Header.Get("Accept-Language"))
+	}))
+	defer server.Close()
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithResponseCache(10))
+
+	// the responses to the different credentials are never shared
+	for _, token := range []string{"alice", "bob", "alice"} {
+		result, err := client.Get(ctx, server.URL+"/", "", SetHeader("Authorization", token))
+		require.NoError(t, err)
+		require.Equal(t, "/ "+token, result)
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&hits))
+
+	for i := 0; i < 2; i++ {
+		result, err := client.Get(ctx, server.URL+"/private", "")
+		require.NoError(t, err)
+		require.Equal(t, "/private ", result)
+	}
+	require.Equal(t, int32(5), atomic.LoadInt32(&hits))
+
+	// the response is selected by the request headers listed in Vary
+	for _, lang := range []string{"en", "en", "fr"} {
+		result, err := client.Get(ctx, server.URL+"/vary", "", SetHeader("Accept-Language", lang))
+		require.NoError(t, err)
+		require.Equal(t, "/vary "+lang, result)
+	}
+	require.Equal(t, int32(7), atomic.LoadInt32(&hits))
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		fmt.Fprint(gw, strings.Repeat("a", 1024))
+		gw.Close()
+	}))
+
+	ctx := context.TODO()
+
+	client := New(Timeout(time.Second*5), WithMaxResponseSize(1024))
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Len(t, result, 1024)
+
+	client = New(Timeout(time.Second*5), WithMaxResponseSize(1023))
+	_, err = client.Get(ctx, server.URL, "")
+	require.True(t, errors.Is(err, ErrResponseTooLarge))
+}
+
+func TestAutoCharset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Content-Type", "text/plain; charset=GBK")
+			data, _ := simplifiedchinese.GBK.NewEncoder().String("你好，世界")
+			fmt.Fprint(w, data)
+		case "/meta":
+			w.Header().Set("Content-Type", "text/html")
+			data, _ := simplifiedchinese.GBK.NewEncoder().String(`<html><head><meta charset="gbk"></head><body>你好</body></html>`)
+			fmt.Fprint(w, data)
+		}
+	}))
+
+	ctx := context.TODO()
+
+	client := New(Timeout(time.Second * 5))
+	result, err := client.Get(ctx, server.URL+"/header", "")
+	require.NoError(t, err)
+	require.NotEqual(t, "你好，世界", result)
+
+	client = New(Timeout(time.Second*5), WithAutoCharset())
+	resp, err := client.DoResponse(ctx, "GET", server.URL+"/header", "")
+	require.NoError(t, err)
+	require.Equal(t, "你好，世界", resp.Result)
+	require.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	result, err = client.Get(ctx, server.URL+"/meta", "")
+	require.NoError(t, err)
+	require.Contains(t, result, "<body>你好</body>")
+}
+
+func TestDuplicateHeaderPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = []string{"text/plain; charset=GBK", "text/plain; charset=utf-8"}
+		fmt.Fprint(w, "你好")
+	}))
+
+	ctx := context.TODO()
+
+	client := New(Timeout(time.Second * 5))
+	resp, err := client.DoResponse(ctx, "GET", server.URL, "")
+	require.NoError(t, err)
+	require.Len(t, resp.Header.Values("Content-Type"), 2)
+
+	client = New(Timeout(time.Second*5), WithAutoCharset(), WithDuplicateHeaderPolicy(DuplicateHeaderLast))
+	resp, err = client.DoResponse(ctx, "GET", server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"text/plain; charset=utf-8"}, resp.Header.Values("Content-Type"))
+	require.Equal(t, "你好", resp.Result)
+
+	client = New(Timeout(time.Second*5), WithAutoCharset(), WithDuplicateHeaderPolicy(DuplicateHeaderFirst))
+	resp, err = client.DoResponse(ctx, "GET", server.URL, "")
+	require.NoError(t, err)
+	require.NotEqual(t, "你好", resp.Result)
+}
+
+func TestResponseCacheFreshness(t *testing.T) {
+	var hits, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=120")
+		w.Header().Set("Age", "100")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "config")
+	}))
+
+	now := time.Now()
+	client := New(Timeout(time.Second*5), WithResponseCache(10))
+	client.respCache.now = func() time.Time { return now }
+
+	ctx := context.TODO()
+	get := func() {
+		resp, err := client.DoResponse(ctx, "GET", server.URL, "")
+		require.NoError(t, err)
+		require.Equal(t, "config", resp.Result)
+	}
+
+	get()
+	require.Equal(t, 1, hits)
+
+	now = now.Add(19 * time.Second)
+	get()
+	require.Equal(t, 1, hits)
+
+	now = now.Add(2 * time.Second)
+	get()
+	require.Equal(t, 2, hits)
+	require.Equal(t, 1, notModified)
+
+	get()
+	require.Equal(t, 2, hits)
+
+	stale, fresh := client.respCache.lookup(httptest.NewRequest("GET", server.URL, nil))
+	require.Nil(t, stale)
+	require.NotNil(t, fresh)
+	require.Equal(t, "100", fresh.Header.Get("Age"))
+}
+
+func TestResponsePipeline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Encoding", "x-custom")
+		gw := gzip.NewWriter(w)
+		fmt.Fprint(gw, `{"code":0,"data":{"name":"tom"}}`)
+		gw.Close()
+	}))
+
+	var order []string
+	decompress := ResponseStage{
+		Match: MatchContentType("application/json"),
+		Transform: func(resp *http.Response, data []byte) ([]byte, error) {
+			order = append(order, "decompress")
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			resp.Header.Del("Content-Encoding")
+			return ioutil.ReadAll(zr)
+		},
+	}
+	unwrap := ResponseStage{
+		Match: MatchContentType("application/json"),
+		Transform: func(resp *http.Response, data []byte) ([]byte, error) {
+			order = append(order, "unwrap")
+			var envelope struct {
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				return nil, err
+			}
+			return envelope.Data, nil
+		},
+	}
+	skipped := ResponseStage{
+		Match: MatchContentType("application/xml"),
+		Transform: func(resp *http.Response, data []byte) ([]byte, error) {
+			order = append(order, "xml")
+			return data, nil
+		},
+	}
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithResponsePipeline([]ResponseStage{decompress, skipped, unwrap}))
+
+	var user struct {
+		Name string `json:"name"`
+	}
+	err := client.NewJSON().Get(ctx, server.URL, nil, &user)
+	require.NoError(t, err)
+	require.Equal(t, "tom", user.Name)
+	require.Equal(t, []string{"decompress", "unwrap"}, order)
+
+	client = New(Timeout(time.Second*5), WithResponsePipeline([]ResponseStage{unwrap, decompress}))
+	_, err = client.Get(ctx, server.URL, "")
+	require.Error(t, err)
+}
+
+func TestProblemError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plain" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.",`+
+			`"status":422,"detail":"Your current balance is 30, but that costs 50.","instance":"/account/12345/msgs/abc"}`)
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	_, err := client.Get(ctx, server.URL, "")
+	var problem *ProblemError
+	require.True(t, errors.As(err, &problem))
+	require.Equal(t, "https://example.com/probs/out-of-credit", problem.Type)
+	require.Equal(t, "You do not have enough credit.", problem.Title)
+	require.Equal(t, 422, problem.Status)
+	require.Equal(t, "Your current balance is 30, but that costs 50.", problem.Detail)
+	require.Equal(t, "/account/12345/msgs/abc", problem.Instance)
+
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusUnprocessableEntity, httpErr.StatusCode)
+
+	_, err = client.Get(ctx, server.URL+"/plain", "")
+	require.IsType(t, &HTTPError{}, err)
+}
+
+func TestExpectETag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "content")
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+	dir := t.TempDir()
+
+	outFile := filepath.Join(dir, "match.txt")
+	require.NoError(t, client.DownloadFile(ctx, server.URL, outFile, ExpectETag(`"v1"`)))
+	data, err := ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, "content", string(data))
+
+	outFile = filepath.Join(dir, "mismatch.txt")
+	err = client.DownloadFile(ctx, server.URL, outFile, ExpectETag(`"v2"`))
+	var mismatch *ETagMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	require.Equal(t, &ETagMismatchError{Expected: `"v2"`, Actual: `"v1"`}, mismatch)
+	_, err = os.Stat(outFile)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = client.DownloadIfChanged(ctx, server.URL, outFile, ExpectETag(`"v2"`))
+	require.True(t, errors.As(err, &mismatch))
+	_, err = os.Stat(outFile)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/notfound" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		fmt.Fprint(gw, "hello world")
+		gw.Close()
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	buf := &bytes.Buffer{}
+	written, err := client.Download(ctx, server.URL, buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("hello world")), written)
+	require.Equal(t, "hello world", buf.String())
+
+	outFile := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, ioutil.WriteFile(outFile, []byte("untouched"), 0644))
+
+	err = client.DownloadFile(ctx, server.URL+"/notfound", outFile)
+	require.IsType(t, &HTTPError{}, err)
+
+	content, err := ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, "untouched", string(content))
+}
+
+func TestDecodeStream(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	const count = 100000
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json; charset=UTF-8", r.Header.Get("Content-Type"))
+		data, _ := ioutil.ReadAll(r.Body)
+		require.Equal(t, `{"limit":100000}`, string(data))
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+
+		enc := json.NewEncoder(gz)
+		fmt.Fprint(gz, "[")
+		for i := 0; i < count; i++ {
+			if i > 0 {
+				fmt.Fprint(gz, ",")
+			}
+			require.NoError(t, enc.Encode(item{ID: i, Name: fmt.Sprintf("item-%d", i)}))
+		}
+		fmt.Fprint(gz, "]")
+	}))
+
+	ctx := context.TODO()
+	client := NewJSON(Timeout(time.Second * 5))
+
+	var items []item
+	require.NoError(t, client.DecodeStream(ctx, "POST", server.URL, map[string]int{"limit": count}, &items))
+	require.Len(t, items, count)
+	require.Equal(t, item{ID: count - 1, Name: fmt.Sprintf("item-%d", count-1)}, items[count-1])
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	var httpErr *HTTPError
+	err := client.DecodeStream(ctx, "GET", notFound.URL, nil, &items)
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+}
+
+func TestXMLDoStream(t *testing.T) {
+	const count = 50000
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+		fmt.Fprint(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+		for i := 0; i < count; i++ {
+			fmt.Fprintf(w, "<url><loc>http://example.com/page/%d</loc><priority>0.5</priority></url>", i)
+		}
+		fmt.Fprint(w, "</urlset>")
+	}))
+
+	type sitemapURL struct {
+		Loc      string  `xml:"loc"`
+		Priority float64 `xml:"priority"`
+	}
+
+	ctx := context.TODO()
+	client := NewXML(Timeout(time.Second * 5))
+
+	var urls int
+	var last sitemapURL
+	err := client.DoStream(ctx, "GET", server.URL, nil, func(dec *xml.Decoder, start xml.StartElement) error {
+		if start.Name.Local != "url" {
+			return nil
+		}
+		urls++
+		return dec.DecodeElement(&last, &start)
+	})
+	require.NoError(t, err)
+	require.Equal(t, count, urls)
+	require.Equal(t, sitemapURL{Loc: fmt.Sprintf("http://example.com/page/%d", count-1), Priority: 0.5}, last)
+
+	stop := errors.New("stop")
+	urls = 0
+	err = client.DoStream(ctx, "GET", server.URL, nil, func(dec *xml.Decoder, start xml.StartElement) error {
+		if start.Name.Local == "url" {
+			if urls++; urls == 10 {
+				return stop
+			}
+			return dec.Skip()
+		}
+		return nil
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 10, urls)
+}
+
+func TestGetNDJSON(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/x-ndjson", r.Header.Get("Accept"))
+		fmt.Fprint(w, "{\"id\":1,\"type\":\"created\"}\n\n{\"id\":2,\"type\":\"updated\"}\n")
+		if r.URL.Path == "/hang" {
+			w.(http.Flusher).Flush()
+			<-hang
+			return
+		}
+		fmt.Fprint(w, `{"id":3,"type":"deleted"}`)
+	}))
+
+	type event struct {
+		ID   int    `json:"id"`
+		Type string `json:"type"`
+	}
+
+	client := New(Timeout(time.Second * 5))
+	stream, err := client.GetNDJSON(context.TODO(), server.URL)
+	require.NoError(t, err)
+
+	var events []event
+	for {
+		var e event
+		if !stream.Next(&e) {
+			break
+		}
+		events = append(events, e)
+	}
+	require.NoError(t, stream.Err())
+	require.NoError(t, stream.Close())
+	require.Equal(t, []event{{1, "created"}, {2, "updated"}, {3, "deleted"}}, events)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	stream, err = client.GetNDJSON(ctx, server.URL+"/hang")
+	require.NoError(t, err)
+	defer stream.Close()
+
+	var e event
+	require.True(t, stream.Next(&e))
+	require.True(t, stream.Next(&e))
+	cancel()
+	require.False(t, stream.Next(&e))
+	require.True(t, errors.Is(stream.Err(), context.Canceled))
+}
+
+func TestSubscribe(t *testing.T) {
+	var connections int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+		if r.URL.Path == "/closed" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch atomic.AddInt32(&connections, 1) {
+		case 1:
+			require.Empty(t, r.Header.Get("Last-Event-ID"))
+			fmt.Fprint(w, "retry: 10\n\nid: 1\ndata: hello\n\n: keep-alive\n\n")
+			fmt.Fprint(w, "event: update\r\nid: 2\r\ndata: line1\r\ndata:line2\r\n\r\ndata: incomplete\n")
+		default:
+			require.Equal(t, "2", r.Header.Get("Last-Event-ID"))
+			fmt.Fprint(w, "id: 3\ndata: resumed\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	stream, err := client.Subscribe(ctx, server.URL)
+	require.NoError(t, err)
+
+	var events []Event
+	for event := range stream.Events() {
+		if events = append(events, event); len(events) == 3 {
+			break
+		}
+	}
+	require.NoError(t, stream.Close())
+	require.NoError(t, stream.Err())
+	require.Equal(t, []Event{
+		{ID: "1", Event: "message", Data: "hello"},
+		{ID: "2", Event: "update", Data: "line1\nline2"},
+		{ID: "3", Event: "message", Data: "resumed"},
+	}, events)
+	require.Equal(t, int32(2), atomic.LoadInt32(&connections))
+
+	_, ok := <-stream.Events()
+	require.False(t, ok)
+
+	_, err = client.Subscribe(ctx, server.URL+"/closed")
+	require.True(t, errors.Is(err, ErrEventStreamClosed))
+}
+
+func TestGetStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		zw := zlib.NewWriter(w)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(zw, "line %d\n", i)
+		}
+		zw.Close()
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	stream, resp, err := client.GetStream(ctx, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	data, err := ioutil.ReadAll(stream)
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	require.Equal(t, "line 0\nline 1\nline 2\n", string(data))
+}
+
+func TestResumeDownloadFile(t *testing.T) {
+	data := strings.Repeat("hello world", 1000)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if r.URL.Path == "/norange" {
+			fmt.Fprint(w, data)
+			return
+		}
+		http.ServeContent(w, r, "data", time.Time{}, strings.NewReader(data))
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	for _, path := range []string{"/", "/norange"} {
+		ranges = nil
+		outFile := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, ioutil.WriteFile(outFile, []byte(data[:4000]), 0644))
+
+		err := client.ResumeDownloadFile(ctx, server.URL+path, outFile)
+		require.NoError(t, err)
+		require.Equal(t, []string{"bytes=4000-"}, ranges)
+
+		content, err := ioutil.ReadFile(outFile)
+		require.NoError(t, err)
+		require.Equal(t, data, string(content))
+	}
+
+	outFile := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, ioutil.WriteFile(outFile, []byte(data), 0644))
+	require.NoError(t, client.ResumeDownloadFile(ctx, server.URL, outFile))
+
+	content, err := ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, data, string(content))
+}
+
+func TestDownloadIfChanged(t *testing.T) {
+	const etag = `"v1"`
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, "hello world")
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+	outFile := filepath.Join(t.TempDir(), "out")
+
+	changed, err := client.DownloadIfChanged(ctx, server.URL, outFile)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	// overwrite the file to detect whether it is rewritten
+	require.NoError(t, ioutil.WriteFile(outFile, []byte("untouched"), 0644))
+
+	changed, err = client.DownloadIfChanged(ctx, server.URL, outFile)
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Equal(t, 2, hits)
+
+	content, err := ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, "untouched", string(content))
+}
+
+func TestDownloadIfChangedInterrupted(t *testing.T) {
+	var version, cut int32 = 1, 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, atomic.LoadInt32(&version))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if atomic.LoadInt32(&cut) == 1 {
+			// the body is cut after the first bytes
+			w.Header().Set("Content-Length", "100")
+		}
+		fmt.Fprint(w, "content "+etag)
+	}))
+	defer server.Close()
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "out")
+
+	_, err := client.DownloadIfChanged(ctx, server.URL, outFile)
+	require.Error(t, err)
+	_, err = os.Stat(outFile)
+	require.True(t, os.IsNotExist(err))
+
+	atomic.StoreInt32(&cut, 0)
+	changed, err := client.DownloadIfChanged(ctx, server.URL, outFile)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	// the interrupted download of the new version keeps the previous file and its ETag
+	atomic.StoreInt32(&version, 2)
+	atomic.StoreInt32(&cut, 1)
+	changed, err = client.DownloadIfChanged(ctx, server.URL, outFile)
+	require.Error(t, err)
+	require.False(t, changed)
+	content, err := ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, `content "v1"`, string(content))
+
+	atomic.StoreInt32(&cut, 0)
+	changed, err = client.DownloadIfChanged(ctx, server.URL, outFile)
+	require.NoError(t, err)
+	require.True(t, changed)
+	content, err = ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, `content "v2"`, string(content))
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestExpect100ContinueFallback(t *testing.T) {
+	var expects []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expects = append(expects, r.Header.Get("Expect"))
+		if r.Header.Get("Expect") != "" {
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Write(data)
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	result, err := client.Post(ctx, server.URL, "hello world", Expect100Continue())
+	require.NoError(t, err)
+	require.Equal(t, "hello world", result)
+	require.Equal(t, []string{"100-continue", ""}, expects)
+}
+
+func TestPartialBodyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprint(w, "hello world")
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	_, err := client.Get(ctx, server.URL, "")
+	require.IsType(t, &PartialBodyError{}, err)
+	require.Equal(t, "hello world", string(err.(*PartialBodyError).Bytes))
+	require.Equal(t, io.ErrUnexpectedEOF, err.(*PartialBodyError).Err)
+}
+
+func TestRedirectConfig(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "other")
+	}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/1", http.RedirectHandler("/2", http.StatusFound))
+	mux.Handle("/2", http.RedirectHandler("/3", http.StatusFound))
+	mux.Handle("/3", http.RedirectHandler("/4", http.StatusFound))
+	mux.Handle("/other", http.RedirectHandler(other.URL, http.StatusFound))
+	mux.HandleFunc("/4", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	})
+	server := httptest.NewServer(mux)
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithRedirectConfig(RedirectConfig{
+		MaxRedirects:          3,
+		SameHostOnly:          true,
+		RejectSchemeDowngrade: true,
+		PreserveHeaders:       []string{"Authorization"},
+	}))
+
+	result, err := client.Get(ctx, server.URL+"/1", "", SetHeader("Authorization", "Bearer token"))
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token", result)
+
+	_, err = client.Get(ctx, server.URL+"/other", "")
+	require.True(t, errors.Is(err, ErrCrossHostRedirect))
+
+	client = New(Timeout(time.Second*5), WithRedirectConfig(RedirectConfig{MaxRedirects: 2}))
+	_, err = client.Get(ctx, server.URL+"/1", "")
+	require.Error(t, err)
+}
+
+func TestClone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Endpoint"))
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+	client.SetDefaultReqOpts(SetHeader("X-Endpoint", "parent"))
+
+	clone := client.Clone(Timeout(time.Second))
+	clone.SetDefaultReqOpts(append(clone.reqOpts, SetHeader("X-Endpoint", "clone"))...)
+
+	require.Equal(t, time.Second, clone.Timeout)
+	require.Equal(t, time.Second*5, client.Timeout)
+
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "parent", result)
+
+	result, err = clone.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "clone", result)
+}
+
+func TestDownloadFileParallel(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000) + "tail"
+	var rangeRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/norange" {
+			fmt.Fprint(w, data)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&rangeRequests, 1)
+		}
+		http.ServeContent(w, r, "data", time.Time{}, strings.NewReader(data))
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	for _, path := range []string{"/", "/norange"} {
+		outFile := filepath.Join(t.TempDir(), "out")
+		err := client.DownloadFileParallel(ctx, server.URL+path, outFile, 4)
+		require.NoError(t, err)
+
+		content, err := ioutil.ReadFile(outFile)
+		require.NoError(t, err)
+		require.Equal(t, data, string(content))
+	}
+	require.Equal(t, int32(5), atomic.LoadInt32(&rangeRequests))
+}
+
+func TestDownloadFileParallelFailure(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the last part fails
+		if strings.HasSuffix(r.Header.Get("Range"), fmt.Sprintf("-%d", len(data)-1)) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "data", time.Time{}, strings.NewReader(data))
+	}))
+	defer server.Close()
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "out")
+	require.NoError(t, ioutil.WriteFile(outFile, []byte("previous"), 0644))
+
+	err := client.DownloadFileParallel(ctx, server.URL, outFile, 4)
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+
+	content, err := ioutil.ReadFile(outFile)
+	require.NoError(t, err)
+	require.Equal(t, "previous", string(content))
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestRetriableResponse(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			fmt.Fprintf(w, "processing")
+			return
+		}
+		fmt.Fprintf(w, "done")
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+		if resp.Result == "processing" {
+			return Retriable(errors.New("job is still processing"))
+		}
+		return nil
+	}))
+	client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "done", result)
+	require.Equal(t, 3, attempts)
+}
+
+func TestStatusValidator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"errmsg":"not found"}`)
+	}))
+
+	ctx := context.TODO()
+
+	_, err := New(Timeout(time.Second*5)).Get(ctx, server.URL, "")
+	require.IsType(t, &HTTPError{}, err)
+
+	client := New(Timeout(time.Second*5), WithStatusValidator(func(code int) bool {
+		return code == http.StatusOK || code == http.StatusNotFound
+	}))
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, `{"errmsg":"not found"}`, result)
+}
+
+func TestConnMaxLifetime(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello world")
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithConnMaxLifetime(100*time.Millisecond))
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Get(ctx, server.URL, "")
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&conns))
+
+	time.Sleep(200 * time.Millisecond)
+
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", result)
+	require.Equal(t, int32(2), atomic.LoadInt32(&conns))
+
+	// the upload crossing the lifetime is not interrupted
+	slowBody := func(ctx context.Context, req *http.Request) (context.Context, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			for i := 0; i < 5; i++ {
+				time.Sleep(50 * time.Millisecond)
+				pw.Write([]byte("chunk"))
+			}
+			pw.Close()
+		}()
+		req.Body = pr
+		return ctx, nil
+	}
+	_, err = client.Post(ctx, server.URL, "", slowBody)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&conns))
+
+	_, err = client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&conns))
+}
+
+func TestRetryIfHeader(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("X-Served-By-Fallback", "true")
+		}
+		fmt.Fprintf(w, "hello world")
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), RetryIfHeader(func(h http.Header) bool {
+		return h.Get("X-Served-By-Fallback") == "true"
+	}))
+	client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", result)
+	require.Equal(t, 3, attempts)
+}
+
+func TestRetryCanceled(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		fmt.Fprintf(w, "processing")
+	}))
+
+	client := New(Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+		return Retriable(errors.New("job is still processing"))
+	}))
+	client.SetRetry([]time.Duration{time.Second, time.Second, time.Second})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	begin := time.Now()
+	_, err := client.Get(ctx, server.URL, "")
+	require.Equal(t, context.Canceled, err)
+	require.True(t, time.Since(begin) < 500*time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestRetrySucceedBeforeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "done")
+	}))
+	defer server.Close()
+
+	client := New(Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+		// the context is done after the response is received
+		cancel()
+		return nil
+	}))
+	client.SetRetry([]time.Duration{time.Second})
+
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "done", result)
+}
+
+func TestRetryOverride(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		fmt.Fprintf(w, "processing")
+	}))
+
+	client := New(Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+		return Retriable(errors.New("job is still processing"))
+	}))
+	client.SetRetry([]time.Duration{time.Millisecond})
+
+	attempts = 0
+	_, err := client.Get(context.TODO(), server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 2, attempts)
+
+	attempts = 0
+	ctx := ContextWithRetry(context.TODO(), []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+	_, err = client.Get(ctx, server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 4, attempts)
+
+	attempts = 0
+	_, err = client.Get(ContextWithoutRetry(context.TODO()), server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestRetryMethods(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		fmt.Fprint(w, "processing")
+	}))
+
+	newClient := func(opts ...ClientOption) *Client {
+		opts = append(opts, Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+			return Retriable(errors.New("job is still processing"))
+		}))
+		client := New(opts...)
+		client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond})
+		return client
+	}
+
+	ctx := context.TODO()
+	client := newClient()
+
+	attempts = 0
+	_, err := client.Get(ctx, server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	_, err = client.Post(ctx, server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+
+	attempts = 0
+	_, err = client.Post(ctx, server.URL, "", SetHeader(IdempotencyKeyHeader, "key"))
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+
+	client = newClient(WithRetryMethods("post"))
+
+	attempts = 0
+	_, err = client.Post(ctx, server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	_, err = client.Get(ctx, server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestMaxRetryElapsedTime(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "processing")
+	}))
+
+	client := New(Timeout(time.Second*5), WithMaxRetryElapsedTime(300*time.Millisecond), WithResponseValidator(func(resp *Response) error {
+		return Retriable(errors.New("job is still processing"))
+	}))
+	client.SetRetry([]time.Duration{50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond})
+
+	begin := time.Now()
+	_, err := client.Get(context.TODO(), server.URL, "")
+	require.Error(t, err)
+	require.True(t, time.Since(begin) < 500*time.Millisecond, "elapsed: %v", time.Since(begin))
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestOnRetry(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			fmt.Fprint(w, "processing")
+			return
+		}
+		fmt.Fprint(w, "done")
+	}))
+
+	type retried struct {
+		attempt     int
+		err         string
+		nextBackoff time.Duration
+	}
+	var calls []retried
+	client := New(Timeout(time.Second*5), WithOnRetry(func(attempt int, err error, nextBackoff time.Duration) {
+		calls = append(calls, retried{attempt, err.Error(), nextBackoff})
+	}), WithResponseValidator(func(resp *Response) error {
+		if resp.Result == "processing" {
+			return Retriable(errors.New("job is still processing"))
+		}
+		return nil
+	}))
+	client.SetRetry([]time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond})
+
+	result, err := client.Get(context.TODO(), server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "done", result)
+	require.Equal(t, []retried{
+		{1, "job is still processing", time.Millisecond},
+		{2, "job is still processing", 2 * time.Millisecond},
+	}, calls)
+}
+
+func TestRequestQuota(t *testing.T) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithRequestQuota(3))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Get(ctx, server.URL, "")
+		require.NoError(t, err)
+	}
+
+	_, err := client.Get(ctx, server.URL, "")
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	require.Equal(t, int32(3), atomic.LoadInt32(&received))
+
+	// the requests never sent are not counted
+	client = New(Timeout(time.Second*5), WithRequestQuota(2), WithMaxConcurrency(1))
+	stream, _, err := client.GetStream(ctx, server.URL)
+	require.NoError(t, err)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = client.Get(timeoutCtx, server.URL, "")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.NoError(t, stream.Close())
+
+	_, err = client.Get(ctx, server.URL, "", SetMultipart(nil, nil))
+	require.True(t, errors.Is(err, ErrBodyNotAllowed))
+
+	_, err = client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	// the body of the request refused is closed
+	closed := false
+	_, err = client.Post(ctx, server.URL, "", func(ctx context.Context, req *http.Request) (context.Context, error) {
+		req.Body = &closeTracker{Reader: strings.NewReader("data"), closed: &closed}
+		return ctx, nil
+	})
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	require.True(t, closed)
+	require.Equal(t, int32(5), atomic.LoadInt32(&received))
+}
+
+// closeTracker records whether it is closed
+type closeTracker struct {
+	io.Reader
+	closed *bool
+}
+
+// Close implements the io.Closer interface
+func (c *closeTracker) Close() error {
+	*c.closed = true
+	return nil
+}
+
+func TestSetIdempotencyKey(t *testing.T) {
+	var parents, attempts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parents = append(parents, r.Header.Get(IdempotencyKeyHeader))
+		attempts = append(attempts, r.Header.Get(AttemptKeyHeader))
+		if len(attempts)%3 != 0 {
+			fmt.Fprint(w, "busy")
+		}
+	}))
+
+	client := New(Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+		if resp.Result == "busy" {
+			return Retriable(errors.New("server busy"))
+		}
+		return nil
+	}))
+	client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond})
+
+	_, err := client.Post(context.TODO(), server.URL, "{}", SetIdempotencyKey("order-42"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"order-42", "order-42", "order-42"}, parents)
+	require.Equal(t, []string{"order-42-1", "order-42-2", "order-42-3"}, attempts)
+
+	parents, attempts = nil, nil
+	_, err = client.Post(context.TODO(), server.URL, "{}", SetIdempotencyKey(""))
+	require.NoError(t, err)
+	require.Len(t, parents, 3)
+	require.Len(t, parents[0], 36)
+	require.Equal(t, []string{parents[0], parents[0], parents[0]}, parents)
+	require.Equal(t, parents[0]+"-3", attempts[2])
+}
+
+func TestPerAttemptTimeout(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		fmt.Fprint(w, "done")
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithPerAttemptTimeout(50*time.Millisecond))
+	client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "done", result)
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	atomic.StoreInt32(&attempts, 0)
+	client = New(Timeout(80*time.Millisecond), WithPerAttemptTimeout(50*time.Millisecond))
+	client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond})
+
+	_, err = client.Get(ctx, server.URL, "")
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "error: %v", err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestSleepFunc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "processing")
+	}))
+
+	var slept []time.Duration
+	client := New(Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+		return Retriable(errors.New("job is still processing"))
+	}), WithSleepFunc(func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return ctx.Err()
+	}))
+	client.SetRetry([]time.Duration{time.Hour, 2 * time.Hour})
+
+	_, err := client.Get(context.TODO(), server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, []time.Duration{time.Hour, 2 * time.Hour}, slept)
+}
+
+func TestHTTP2Toggle(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+	newTransport := func() *http.Transport {
+		return &http.Transport{TLSClientConfig: tlsConfig.Clone()}
+	}
+
+	ctx := context.TODO()
+
+	client := New(Timeout(time.Second*5), SetTransport(newTransport()), WithHTTP2())
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/2.0", result)
+
+	client = New(Timeout(time.Second*5), SetTransport(newTransport()), WithHTTP2(), WithForceHTTP1())
+	result, err = client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.1", result)
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client := New(Timeout(time.Second*5), WithUnixSocket(path))
+	result, err := client.Get(context.TODO(), "http://unix/v1/containers", "")
+	require.NoError(t, err)
+	require.Equal(t, "/v1/containers", result)
+}
+
+func TestStartupProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "HEAD", r.Method)
+	}))
+
+	ctx := context.TODO()
+	client, err := NewWithError(ctx, Timeout(time.Second*5), WithStartupProbe(server.URL+"/health", time.Second))
+	require.NoError(t, err)
+	require.NotNil(t, client)
+
+	server.Close()
+	client, err = NewWithError(ctx, Timeout(time.Second*5), WithStartupProbe(server.URL+"/health", time.Second))
+	require.Error(t, err)
+	require.Nil(t, client)
+
+	client = New(Timeout(time.Second*5), WithStartupProbe(server.URL+"/health", time.Second))
+	require.NotNil(t, client)
+}
+
+func TestTLSSessionCache(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	rootCAs := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	newTransport := func() *http.Transport {
+		return &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}}
+	}
+
+	ctx := context.TODO()
+	for _, c := range []struct {
+		opts   []ClientOption
+		resume bool
+	}{
+		{[]ClientOption{SetTransport(newTransport())}, false},
+		{[]ClientOption{SetTransport(newTransport()), WithTLSSessionCache(nil)}, true},
+		{[]ClientOption{SetTransport(newTransport()), WithTLSSessionCache(tls.NewLRUClientSessionCache(1))}, true},
+	} {
+		client := New(append([]ClientOption{Timeout(time.Second * 5)}, c.opts...)...)
+
+		resp, err := client.DoResponse(ctx, "GET", server.URL, "")
+		require.NoError(t, err)
+		require.False(t, resp.TLS.DidResume)
+
+		client.CloseIdleConnections()
+		resp, err = client.DoResponse(ctx, "GET", server.URL, "")
+		require.NoError(t, err)
+		require.Equal(t, c.resume, resp.TLS.DidResume)
+	}
+}
+
+func TestStrictOptions(t *testing.T) {
+	ctx := context.TODO()
+
+	client, err := NewWithError(ctx, StrictOptions(), SetTransport(&http.Transport{}), WithTLSSessionCache(nil), WithMaxRedirects(3))
+	require.NoError(t, err)
+	require.NotNil(t, client)
+
+	_, err = NewWithError(ctx, WithMaxRedirects(3), WithRedirectPolicy(func(req *http.Request, via []*http.Request) error {
+		return nil
+	}), StrictOptions())
+	require.True(t, errors.Is(err, ErrConflictingOptions))
+	require.Contains(t, err.Error(), "WithRedirectPolicy overrides WithMaxRedirects")
+
+	_, err = NewWithError(ctx, StrictOptions(), WithClientCert(tls.Certificate{}), SetTransport(&http.Transport{}))
+	require.True(t, errors.Is(err, ErrConflictingOptions))
+	require.Contains(t, err.Error(), "SetTransport overrides the transport set or customized before")
+
+	_, err = NewWithError(ctx, StrictOptions(), WithHTTP2(), WithForceHTTP1(), DisableRedirect, WithRedirectConfig(RedirectConfig{}))
+	require.True(t, errors.Is(err, ErrConflictingOptions))
+	require.Contains(t, err.Error(), "WithForceHTTP1 overrides WithHTTP2; WithRedirectConfig overrides DisableRedirect")
+
+	// the transport options are ignored by the custom transport
+	custom := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("unreachable")
+	})
+	_, err = NewWithError(ctx, StrictOptions(), SetTransport(custom), WithDNSCache(time.Minute), WithForceHTTP1())
+	require.True(t, errors.Is(err, ErrConflictingOptions))
+	require.Contains(t, err.Error(), "WithDNSCache is ignored by the transport set by SetTransport; "+
+		"WithForceHTTP1 is ignored by the transport set by SetTransport")
+
+	client, err = NewWithError(ctx, WithMaxRedirects(3), DisableRedirect)
+	require.NoError(t, err)
+	require.NotNil(t, client)
+
+	// the clones record their conflicts apart
+	client = New()
+	client.optConflicts = make([]string, 0, 4)
+	clone1 := client.Clone(WithMaxRedirects(3), DisableRedirect)
+	clone2 := client.Clone(DisableRedirect, WithMaxRedirects(3))
+	require.Equal(t, []string{"DisableRedirect overrides WithMaxRedirects"}, clone1.optConflicts)
+	require.Equal(t, []string{"WithMaxRedirects overrides DisableRedirect"}, clone2.optConflicts)
+}
+
+func TestClientCert(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	rootCAs := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	newTransport := func() *http.Transport {
+		return &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}}
+	}
+
+	ctx := context.TODO()
+
+	client := New(Timeout(time.Second*5), SetTransport(newTransport()))
+	_, err = client.Get(ctx, server.URL, "")
+	require.Error(t, err)
+
+	client, err = NewWithError(ctx, Timeout(time.Second*5), SetTransport(newTransport()), WithClientCertFromFiles(certFile, keyFile))
+	require.NoError(t, err)
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "client", result)
+
+	missingFile := filepath.Join(dir, "missing.crt")
+	client, err = NewWithError(ctx, WithClientCertFromFiles(missingFile, keyFile))
+	require.Nil(t, client)
+	require.True(t, errors.Is(err, os.ErrNotExist))
+	require.Contains(t, err.Error(), missingFile)
+
+	malformedFile := filepath.Join(dir, "malformed.crt")
+	require.NoError(t, ioutil.WriteFile(malformedFile, []byte("not a certificate"), 0600))
+	_, err = NewWithError(ctx, WithClientCertFromFiles(malformedFile, keyFile))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), malformedFile)
+
+	_, err = NewWithError(ctx, WithClientCertFiles(certFile, keyFile))
+	require.NoError(t, err)
+}
+
+func TestRedirectResendBody(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusPermanentRedirect)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r.Method+" "+string(data))
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	_, err := client.Post(ctx, server.URL+"/old", "hello")
+	require.NoError(t, err)
+
+	_, err = client.Post(ctx, server.URL+"/old", "", SetFormData(url.Values{"a": {"1"}}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"POST hello", "POST a=1"}, received)
+
+	replaceBody := func(ctx context.Context, req *http.Request) (context.Context, error) {
+		req.Body = ioutil.NopCloser(strings.NewReader("replaced"))
+		req.ContentLength = int64(len("replaced"))
+		return ctx, nil
+	}
+	_, err = client.Post(ctx, server.URL+"/old", "hello", replaceBody)
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusPermanentRedirect, httpErr.StatusCode)
+	require.Len(t, received, 2)
+}
+
+func TestBinaryLogSafe(t *testing.T) {
+	binary := string([]byte{0x00, 0x01, 0x02, 0xff})
+
+	client := New()
+	require.Equal(t, binary, client.logText(binary))
+
+	client = New(WithBinaryLogSafe())
+	require.Equal(t, "<binary 4 bytes, base64: AAEC/w==>", client.logText(binary))
+	require.Equal(t, "hello 世界\n", client.logText("hello 世界\n"))
+
+	long := strings.Repeat("\x00", 100)
+	require.Equal(t, "<binary 100 bytes, base64: "+base64.StdEncoding.EncodeToString([]byte(long[:64]))+">", client.logText(long))
+}
+
+func TestTestResponses(t *testing.T) {
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+	client.SetTestResponses(map[string]TestResponse{
+		"GET http://example.com/users?id=1": {Body: `{"name":"tom"}`},
+		"POST http://example.com/users":     {StatusCode: http.StatusConflict, Body: "exists"},
+	})
+
+	var user struct {
+		Name string `json:"name"`
+	}
+	err := client.NewJSON().Get(ctx, "http://example.com/users", nil, &user, SetQuery(url.Values{"id": {"1"}}))
+	require.NoError(t, err)
+	require.Equal(t, "tom", user.Name)
+
+	_, err = client.Post(ctx, "http://example.com/users", "name=tom", SetTypeForm())
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusConflict, httpErr.StatusCode)
+
+	_, err = client.Delete(ctx, "http://example.com/users", "")
+	require.True(t, errors.Is(err, ErrNoTestResponse))
+
+	recorded := client.RecordedRequests()
+	require.Len(t, recorded, 3)
+	require.Equal(t, "GET", recorded[0].Method)
+	require.Equal(t, "http://example.com/users?id=1", recorded[0].URL)
+	require.Equal(t, "name=tom", recorded[1].Body)
+	require.Equal(t, "application/x-www-form-urlencoded", recorded[1].Header.Get("Content-Type"))
+}
+
+func TestRedirectLimitAndPolicy(t *testing.T) {
+	var auths []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cross" {
+			http.Redirect(w, r, target.URL, http.StatusFound)
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if n > 0 {
+			http.Redirect(w, r, "/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		auths = append(auths, r.Header.Get("Authorization"))
+	}))
+
+	ctx := context.TODO()
+
+	client := New(Timeout(time.Second*5), WithMaxRedirects(3))
+	_, err := client.Get(ctx, server.URL+"/3", "")
+	require.NoError(t, err)
+	_, err = client.Get(ctx, server.URL+"/4", "")
+	require.Error(t, err)
+
+	client = New(Timeout(time.Second*5), WithMaxRedirects(0))
+	resp, err := client.DoResponse(ctx, "GET", server.URL+"/1", "")
+	require.IsType(t, &HTTPError{}, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	var checked int
+	client = New(Timeout(time.Second*5), WithRedirectPolicy(func(req *http.Request, via []*http.Request) error {
+		checked++
+		if req.URL.Host != via[0].URL.Host {
+			req.Header.Del("Authorization")
+		}
+		return nil
+	}))
+	auths = nil
+	_, err = client.Get(ctx, server.URL+"/1", "", SetHeader("Authorization", "Bearer token"))
+	require.NoError(t, err)
+	_, err = client.Get(ctx, server.URL+"/cross", "", SetHeader("Authorization", "Bearer token"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Bearer token", ""}, auths)
+	require.Equal(t, 2, checked)
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Hooked") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+
+	var (
+		methods  []string
+		statuses []int
+	)
+	client := New(Timeout(time.Second*5), WithOnRequest(func(req *http.Request) {
+		req.Header.Set("X-Hooked", "1")
+		methods = append(methods, req.Method)
+	}), WithOnResponse(func(resp *http.Response, elapsed time.Duration) {
+		require.True(t, elapsed > 0)
+		statuses = append(statuses, resp.StatusCode)
+	}))
+
+	ctx := context.TODO()
+	_, err := client.Post(ctx, server.URL, "")
+	require.NoError(t, err)
+
+	_, err = client.Download(ctx, server.URL, ioutil.Discard)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"POST", "GET"}, methods)
+	require.Equal(t, []int{http.StatusOK, http.StatusOK}, statuses)
+}
+
+func TestFailureCounting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "processing")
+	}))
+
+	newClient := func(countRetries bool) *Client {
+		client := New(Timeout(time.Second*5),
+			WithCircuitBreaker(breaker.New(3, 1, time.Minute)),
+			WithFailureCounting(countRetries),
+			WithResponseValidator(func(resp *Response) error {
+				return Retriable(errors.New("job is still processing"))
+			}),
+		)
+		client.SetRetry([]time.Duration{time.Millisecond, time.Millisecond})
+		return client
+	}
+
+	ctx := context.TODO()
+
+	client := newClient(true)
+	_, err := client.Get(ctx, server.URL, "")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, breaker.ErrBreakerOpen))
+	_, err = client.Get(ctx, server.URL, "")
+	require.True(t, errors.Is(err, breaker.ErrBreakerOpen))
+
+	client = newClient(false)
+	for i := 0; i < 3; i++ {
+		_, err = client.Get(ctx, server.URL, "")
+		require.Error(t, err)
+		require.False(t, errors.Is(err, breaker.ErrBreakerOpen))
+	}
+	_, err = client.Get(ctx, server.URL, "")
+	require.True(t, errors.Is(err, breaker.ErrBreakerOpen))
+}
+
+func TestRequestID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get(RequestIDHeader))
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), WithRequestID(nil))
+
+	id1, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	id2, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id1)
+	require.NotEqual(t, id1, id2)
+
+	id, err := client.Get(ctx, server.URL, "", SetHeader(RequestIDHeader, "fixed"))
+	require.NoError(t, err)
+	require.Equal(t, "fixed", id)
+
+	// the request id is set before the request is signed
+	var signed string
+	sign := func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return withSigner(ctx, func(ctx context.Context, req *http.Request) error {
+			signed = req.Header.Get(RequestIDHeader)
+			return nil
+		}), nil
+	}
+	id, err = client.Get(ctx, server.URL, "", sign)
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+	require.Equal(t, id, signed)
+}
+
+func TestBatchGet(t *testing.T) {
+	var running, maxRunning int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+
+	urls := []string{server.URL + "/a", server.URL + "/b", server.URL + "/fail", server.URL + "/c", server.URL + "/d"}
+	client := New(Timeout(time.Second * 5))
+	results := client.BatchGet(context.TODO(), urls, 2)
+
+	require.Len(t, results, len(urls))
+	for i, result := range results {
+		require.Equal(t, urls[i], result.URL)
+		if i == 2 {
+			require.IsType(t, &HTTPError{}, result.Err)
+			continue
+		}
+		require.NoError(t, result.Err)
+		require.Equal(t, strings.TrimPrefix(urls[i], server.URL), result.Body)
+	}
+	require.True(t, atomic.LoadInt32(&maxRunning) <= 2)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	results = client.BatchGet(ctx, urls, 2)
+	for _, result := range results {
+		require.True(t, errors.Is(result.Err, context.Canceled))
+	}
+}
+
+func TestBodyFromChannel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, []string{"chunked"}, r.TransferEncoding)
+		io.Copy(w, r.Body)
+	}))
+
+	ch := make(chan []byte)
+	go func() {
+		for _, chunk := range []string{"hello", " ", "world"} {
+			ch <- []byte(chunk)
+		}
+		close(ch)
+	}()
+
+	client := New(Timeout(time.Second * 5))
+	result, err := client.Post(context.TODO(), server.URL, "", BodyFromChannel(ch))
+	require.NoError(t, err)
+	require.Equal(t, "hello world", result)
+
+	_, err = client.Get(context.TODO(), server.URL, "", BodyFromChannel(ch))
+	require.True(t, errors.Is(err, ErrBodyNotAllowed))
+}
+
+func TestUploadFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "PUT", r.Method)
+		require.Empty(t, r.TransferEncoding)
+
+		file, header, err := r.FormFile("upload")
+		require.NoError(t, err)
+		defer file.Close()
+		written, err := io.Copy(ioutil.Discard, file)
+		require.NoError(t, err)
+
+		fmt.Fprintf(w, "%v:%v", header.Filename, written)
+	}))
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	path := filepath.Join(t.TempDir(), "large.bin")
+	require.NoError(t, ioutil.WriteFile(path, content, 0600))
+
+	var sent, total int64
+	var calls int
+	client := New(Timeout(time.Second * 5))
+	result, err := client.UploadFile(context.TODO(), "PUT", server.URL, "upload", path, func(written, size int64) {
+		require.True(t, written >= sent)
+		sent, total = written, size
+		calls++
+	})
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("large.bin:%v", len(content)), result)
+	require.Equal(t, int64(len(content)), sent)
+	require.Equal(t, int64(len(content)), total)
+	require.True(t, calls > 1)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = client.UploadFile(ctx, "POST", server.URL, "upload", path, nil)
+	require.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestSetMultipart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.TransferEncoding)
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+
+		file, header, err := r.FormFile("object")
+		require.NoError(t, err)
+		defer file.Close()
+		content, err := ioutil.ReadAll(file)
+		require.NoError(t, err)
+
+		fmt.Fprintf(w, "%v,%v,%v:%s", r.FormValue("bucket"), r.FormValue("key"), header.Filename, content)
+	}))
+
+	path := filepath.Join(t.TempDir(), "object.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("object content"), 0600))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+	result, err := client.Post(ctx, server.URL, "", SetMultipart(map[string]string{"bucket": "photos", "key": "a/b.txt"}, map[string]string{"object": path}))
+	require.NoError(t, err)
+	require.Equal(t, "photos,a/b.txt,object.txt:object content", result)
+
+	_, err = client.Post(ctx, server.URL, "", SetMultipart(nil, map[string]string{"object": path + ".missing"}))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	_, err = client.Get(ctx, server.URL, "", SetMultipart(nil, map[string]string{"object": path}))
+	require.True(t, errors.Is(err, ErrBodyNotAllowed))
+}
+
+func TestServerTiming(t *testing.T) {
+	header := http.Header{}
+	header.Add("Server-Timing", "db;dur=53, app;dur=47.2")
+	header.Add("Server-Timing", `cache;desc="Cache Read; \"hit\"";dur=0.5, miss, ;dur=1, bad;dur=abc`)
+	require.Equal(t, []ServerTimingMetric{
+		{Name: "db", Duration: 53 * time.Millisecond},
+		{Name: "app", Duration: 47200 * time.Microsecond},
+		{Name: "cache", Duration: 500 * time.Microsecond, Description: `Cache Read; "hit"`},
+		{Name: "miss"},
+		{Name: "bad"},
+	}, ParseServerTiming(header))
+	require.Nil(t, ParseServerTiming(http.Header{}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server-Timing", "db;dur=53, app;dur=47.2")
+	}))
+
+	resp, err := New(Timeout(time.Second*5)).DoResponse(context.TODO(), "GET", server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, []ServerTimingMetric{
+		{Name: "db", Duration: 53 * time.Millisecond},
+		{Name: "app", Duration: 47200 * time.Microsecond},
+	}, resp.ServerTiming)
+}
+
+func TestSignAWSV4(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}
+	authRegexp := regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/(\d{8})/us-east-1/s3/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=[0-9a-f]{64}$`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		matches := authRegexp.FindStringSubmatch(auth)
+		require.NotNil(t, matches, auth)
+		require.Equal(t, "token", r.Header.Get("X-Amz-Security-Token"))
+
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		sum := sha256.Sum256(body)
+		payloadHash := hex.EncodeToString(sum[:])
+		require.Equal(t, payloadHash, r.Header.Get("X-Amz-Content-Sha256"))
+
+		// recompute the signature over the signed headers
+		signed := strings.Split(matches[2], ";")
+		require.Contains(t, signed, "x-amz-meta-owner")
+		req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), bytes.NewReader(body))
+		require.NoError(t, err)
+		for _, name := range signed {
+			if name != "host" {
+				req.Header.Set(name, r.Header.Get(name))
+			}
+		}
+		signingTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		require.NoError(t, err)
+		require.NoError(t, v4.NewSigner().SignHTTP(context.TODO(), creds, req, payloadHash, "s3", "us-east-1", signingTime))
+		require.Equal(t, req.Header.Get("Authorization"), auth)
+	}))
+
+	client := New(Timeout(time.Second * 5))
+	_, err := client.Put(context.TODO(), server.URL+"/bucket/key?acl", "object content",
+		SignAWSV4(creds, "us-east-1", "s3"),
+		SetHeader("X-Amz-Meta-Owner", "alice"),
+	)
+	require.NoError(t, err)
+}
+
+func TestJitterBackoff(t *testing.T) {
+	base, max := 10*time.Millisecond, time.Second
+
+	for n := 0; n < 100; n++ {
+		backoff := FullJitterBackoff(base, max, 10)
+		require.Len(t, backoff, 10)
+		for i, d := range backoff {
+			ceil := base << uint(i)
+			if ceil > max {
+				ceil = max
+			}
+			require.True(t, d >= 0 && d <= ceil, "step: %v, sleep: %v", i, d)
+		}
+
+		backoff = DecorrelatedJitterBackoff(base, max, 10)
+		require.Len(t, backoff, 10)
+		prev := base
+		for i, d := range backoff {
+			require.True(t, d >= base && d <= max && d <= prev*3, "step: %v, sleep: %v", i, d)
+			prev = d
+		}
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users":
+			http.Redirect(w, r, "/users/", http.StatusMovedPermanently)
+		case "/groups/":
+			http.Redirect(w, r, "/groups", http.StatusMovedPermanently)
+		default:
+			fmt.Fprint(w, r.Method+" "+r.URL.RequestURI())
+		}
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), DisableRedirect)
+
+	result, err := client.Post(ctx, server.URL+"/users?a=1", "", EnsureTrailingSlash())
+	require.NoError(t, err)
+	require.Equal(t, "POST /users/?a=1", result)
+
+	result, err = client.Post(ctx, server.URL+"/groups/?a=1", "", StripTrailingSlash())
+	require.NoError(t, err)
+	require.Equal(t, "POST /groups?a=1", result)
+
+	result, err = client.Get(ctx, server.URL+"/?a=1", "", StripTrailingSlash())
+	require.NoError(t, err)
+	require.Equal(t, "GET /?a=1", result)
+
+	result, err = client.Get(ctx, server.URL+"?a=1", "", EnsureTrailingSlash())
+	require.NoError(t, err)
+	require.Equal(t, "GET /?a=1", result)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return false }
+
+func TestRetryClassifier(t *testing.T) {
+	opErr := func(op string, err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: op, Net: "tcp", Err: err}}
+	}
+
+	cases := []struct {
+		name   string
+		err    error
+		action retrier.Action
+	}{
+		{"success", nil, retrier.Succeed},
+		{"logic", errors.New("invalid argument"), retrier.Fail},
+		{"marked", Retriable(errors.New("processing")), retrier.Retry},
+		{"reset", opErr("read", os.NewSyscallError("read", syscall.ECONNRESET)), retrier.Retry},
+		{"refused", opErr("dial", os.NewSyscallError("connect", syscall.ECONNREFUSED)), retrier.Retry},
+		{"broken pipe", opErr("write", os.NewSyscallError("write", syscall.EPIPE)), retrier.Retry},
+		{"timeout", &url.Error{Op: "Get", URL: "http://example.com", Err: timeoutError{}}, retrier.Retry},
+		{"deadline", fmt.Errorf("wrapped: %w", context.DeadlineExceeded), retrier.Retry},
+		{"canceled", &url.Error{Op: "Get", URL: "http://example.com", Err: context.Canceled}, retrier.Fail},
+		{"dns not found", opErr("dial", &net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}), retrier.Fail},
+		{"dns temporary", opErr("dial", &net.DNSError{Err: "server misbehaving", Name: "x", IsTemporary: true}), retrier.Retry},
+		{"dns timeout", opErr("dial", &net.DNSError{Err: "timeout", Name: "x", IsTimeout: true}), retrier.Retry},
+		{"http2", errors.New("stream error: stream ID 1; PROTOCOL_ERROR"), retrier.Retry},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.action, DefaultRetryClassifier.Classify(c.err))
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	_, err := New(Timeout(time.Second*5)).Get(context.TODO(), server.URL, "")
+	require.Equal(t, retrier.Retry, DefaultRetryClassifier.Classify(err))
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+type fixedClassifier struct {
+	action retrier.Action
+	calls  *int
+}
+
+func (c fixedClassifier) Classify(err error) retrier.Action {
+	*c.calls++
+	return c.action
+}
+
+func TestCombinedClassifiers(t *testing.T) {
+	cases := []struct {
+		name     string
+		actions  []retrier.Action
+		any, all retrier.Action
+		anyCalls int
+		allCalls int
+	}{
+		{"none", nil, retrier.Fail, retrier.Fail, 0, 0},
+		{"retry", []retrier.Action{retrier.Retry}, retrier.Retry, retrier.Retry, 1, 1},
+		{"fail", []retrier.Action{retrier.Fail}, retrier.Fail, retrier.Fail, 1, 1},
+		{"retry first", []retrier.Action{retrier.Retry, retrier.Fail}, retrier.Retry, retrier.Fail, 1, 2},
+		{"fail first", []retrier.Action{retrier.Fail, retrier.Retry}, retrier.Retry, retrier.Fail, 2, 1},
+		{"all retry", []retrier.Action{retrier.Retry, retrier.Retry}, retrier.Retry, retrier.Retry, 1, 2},
+		{"all fail", []retrier.Action{retrier.Fail, retrier.Fail}, retrier.Fail, retrier.Fail, 2, 1},
+		{"succeed", []retrier.Action{retrier.Succeed, retrier.Retry}, retrier.Retry, retrier.Fail, 2, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var anyCalls, allCalls int
+			var anyOf, allOf []retrier.Classifier
+			for _, action := range c.actions {
+				anyOf = append(anyOf, fixedClassifier{action, &anyCalls})
+				allOf = append(allOf, fixedClassifier{action, &allCalls})
+			}
+
+			err := errors.New("failed")
+			require.Equal(t, c.any, AnyClassifier(anyOf...).Classify(err))
+			require.Equal(t, c.all, AllClassifier(allOf...).Classify(err))
+			require.Equal(t, c.anyCalls, anyCalls)
+			require.Equal(t, c.allCalls, allCalls)
+
+			require.Equal(t, retrier.Succeed, AnyClassifier(anyOf...).Classify(nil))
+			require.Equal(t, retrier.Succeed, AllClassifier(allOf...).Classify(nil))
+		})
+	}
+
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	client := New(Timeout(time.Second * 5))
+	client.SetRetrier(retrier.New([]time.Duration{time.Millisecond, time.Millisecond}, AnyClassifier(DefaultRetryClassifier, classifierFunc(func(err error) retrier.Action {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusServiceUnavailable {
+			return retrier.Retry
+		}
+		return retrier.Fail
+	}))))
+
+	_, err := client.Get(context.TODO(), server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+}
+
+type classifierFunc func(err error) retrier.Action
+
+func (fn classifierFunc) Classify(err error) retrier.Action {
+	return fn(err)
+}
+
+func TestHTTP2StreamError(t *testing.T) {
+	cases := []struct {
+		err    error
+		code   string
+		action retrier.Action
+	}{
+		{errors.New("stream error: stream ID 3; REFUSED_STREAM; received from peer"), "REFUSED_STREAM", retrier.Retry},
+		{errors.New("stream error: stream ID 3; INTERNAL_ERROR; received from peer"), "INTERNAL_ERROR", retrier.Fail},
+		{http2.StreamError{StreamID: 1, Code: http2.ErrCodeProtocol}, "PROTOCOL_ERROR", retrier.Retry},
+		{http2.GoAwayError{ErrCode: http2.ErrCodeEnhanceYourCalm}, "ENHANCE_YOUR_CALM", retrier.Fail},
+	}
+
+	for _, c := range cases {
+		var attempts int
+		client := New(Timeout(time.Second*5), SetTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return nil, c.err
+		})))
+		client.SetRetry([]time.Duration{time.Millisecond})
+
+		_, err := client.Get(context.TODO(), "http://example.com", "")
+		var h2Err *HTTP2StreamError
+		require.True(t, errors.As(err, &h2Err), "error: %v", err)
+		require.Equal(t, c.code, h2Err.Code)
+		require.Equal(t, c.action, DefaultRetryClassifier.Classify(err))
+		if c.action == retrier.Retry {
+			require.Equal(t, 2, attempts)
+		} else {
+			require.Equal(t, 1, attempts)
+		}
+	}
+
+	require.Nil(t, asHTTP2StreamError(errors.New("connection refused")))
+}
+
+func TestConnectionWarmup(t *testing.T) {
+	var heads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			atomic.AddInt32(&heads, 1)
+		}
+	}))
+
+	var reused []bool
+	trace := func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = append(reused, info.Reused)
+			},
+		}), nil
+	}
+
+	ctx := context.TODO()
+
+	client := New(Timeout(time.Second*5), SetTransport(&http.Transport{}))
+	_, err := client.Get(ctx, server.URL, "", trace)
+	require.NoError(t, err)
+	require.Equal(t, []bool{false}, reused)
+
+	reused = nil
+	client = New(Timeout(time.Second*5), SetTransport(&http.Transport{}), WithConnectionWarmup(server.URL, 2))
+	require.Equal(t, int32(2), atomic.LoadInt32(&heads))
+	_, err = client.Get(ctx, server.URL, "", trace)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true}, reused)
+}
+
+type recordingStrategy struct {
+	attempts []int
+}
+
+func (s *recordingStrategy) NextBackoff(attempt int, lastErr error) (time.Duration, bool) {
+	s.attempts = append(s.attempts, attempt)
+	return time.Millisecond, attempt < 3
+}
+
+func TestBackoffStrategy(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		fmt.Fprint(w, "processing")
+	}))
+
+	strategy := &recordingStrategy{}
+	client := New(Timeout(time.Second*5), WithResponseValidator(func(resp *Response) error {
+		return Retriable(errors.New("job is still processing"))
+	}))
+	client.SetBackoffStrategy(strategy)
+
+	_, err := client.Get(context.TODO(), server.URL, "")
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+	require.Equal(t, []int{1, 2, 3}, strategy.attempts)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		d, ok := FullJitterStrategy(10*time.Millisecond, 15*time.Millisecond, 2).NextBackoff(attempt, err)
+		require.Equal(t, attempt <= 2, ok)
+		require.True(t, d >= 0 && d <= 15*time.Millisecond)
+	}
+
+	retryAfter := RetryAfterStrategy(BackoffSchedule{time.Second})
+	d, ok := retryAfter.NextBackoff(1, &HTTPError{StatusCode: 503, Header: http.Header{"Retry-After": {"5"}}})
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, d)
+	d, ok = retryAfter.NextBackoff(1, errors.New("no header"))
+	require.True(t, ok)
+	require.Equal(t, time.Second, d)
+	_, ok = retryAfter.NextBackoff(2, &HTTPError{StatusCode: 503, Header: http.Header{"Retry-After": {"5"}}})
+	require.False(t, ok)
+}
+
+func TestStreamReadTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pause := 20 * time.Millisecond
+		if r.URL.Path == "/stalled" {
+			pause = time.Second
+		}
+		for i := 0; i < 5; i++ {
+			fmt.Fprint(w, "chunk")
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(pause):
+			}
+		}
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	stream, _, err := client.GetStream(ctx, server.URL+"/steady", SetReadTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("chunk", 5), string(data))
+	require.NoError(t, stream.Close())
+
+	begin := time.Now()
+	stream, _, err = client.GetStream(ctx, server.URL+"/stalled", SetReadTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	data, err = ioutil.ReadAll(stream)
+	require.True(t, errors.Is(err, ErrReadTimeout), "error: %v", err)
+	require.Equal(t, "chunk", string(data))
+	require.True(t, time.Since(begin) < 500*time.Millisecond)
+	stream.Close()
+}
+
+type logEntries struct {
+	sync.Mutex
+	entries []*log.Entry
+}
+
+func (a *logEntries) Append(entry *log.Entry) {
+	a.Lock()
+	defer a.Unlock()
+	a.entries = append(a.entries, entry)
+}
+
+type tenantKey struct{}
+
+func (tenantKey) String() string { return "tenant" }
+
+func TestContextLogKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	appender := &logEntries{}
+	logger := log.GetLogger()
+	log.SetLogger(log.NewLogger(appender))
+	defer log.SetLogger(logger)
+
+	ctx := context.WithValue(context.TODO(), tenantKey{}, "acme")
+	ctx = context.WithValue(ctx, "user", "alice")
+
+	client := New(Timeout(time.Second*5), WithContextLogKeys(tenantKey{}, "user", "trace"))
+	_, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+
+	appender.Lock()
+	defer appender.Unlock()
+	require.NotEmpty(t, appender.entries)
+	for _, entry := range appender.entries {
+		fields := map[interface{}]interface{}{}
+		for i := 0; i+1 < len(entry.KeyVals); i += 2 {
+			fields[entry.KeyVals[i]] = entry.KeyVals[i+1]
+		}
+		require.Equal(t, "acme", fields["tenant"])
+		require.Equal(t, "alice", fields["user"])
+		require.NotContains(t, fields, "trace")
+	}
+}
+
+func TestSetQueryAccumulates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RawQuery)
+	}))
+
+	client := New(Timeout(time.Second * 5))
+	result, err := client.Get(context.TODO(), server.URL+"/?z=1&path=%2Fa%2Fb", "",
+		SetQuery(url.Values{"tag": {"b", "a"}, "id": {"7"}}),
+		SetQuery(url.Values{"z": {"2"}, "q": {"x y"}}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "z=1&path=%2Fa%2Fb&id=7&tag=b&tag=a&q=x+y&z=2", result)
+}
+
+func TestSetQueryParam(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RawQuery)
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second * 5))
+
+	result, err := client.Get(ctx, server.URL+"/?page=1&page=2&size=10", "", SetQueryParam("page", "3"))
+	require.NoError(t, err)
+	require.Equal(t, "page=3&size=10", result)
+
+	result, err = client.Get(ctx, server.URL+"/?page=1&page=2&size=10", "", DelQueryParam("page"))
+	require.NoError(t, err)
+	require.Equal(t, "size=10", result)
+
+	result, err = client.Get(ctx, server.URL+"/?size=10", "", DelQueryParam("page"), SetQueryParam("q", "a b"))
+	require.NoError(t, err)
+	require.Equal(t, "q=a+b&size=10", result)
+}
+
+func TestGetWithCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "csrf_token", Value: "abc123", Path: "/", HttpOnly: true})
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		fmt.Fprint(w, "ok")
+	}))
+
+	client := New(Timeout(time.Second * 5))
+	result, cookies, err := client.GetWithCookies(context.TODO(), server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "ok", result)
+	require.Len(t, cookies, 2)
+	require.Equal(t, "csrf_token", cookies[0].Name)
+	require.Equal(t, "abc123", cookies[0].Value)
+	require.Equal(t, "/", cookies[0].Path)
+	require.True(t, cookies[0].HttpOnly)
+	require.Equal(t, "session", cookies[1].Name)
+	require.Equal(t, "s1", cookies[1].Value)
+}
+
+// countingTokenSource returns the numbered tokens, counting the calls
+type countingTokenSource struct {
+	tokens []*oauth2.Token
+}
+
+// Token implements the oauth2.TokenSource interface
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	token := &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", len(s.tokens)+1), Expiry: time.Now().Add(time.Hour)}
+	s.tokens = append(s.tokens, token)
+	return token, nil
+}
+
+func TestWithOAuth2(t *testing.T) {
+	ctx := context.TODO()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	src := &countingTokenSource{}
+	client := New(WithOAuth2(src), WithTLSSessionCache(nil))
+
+	transport, ok := client.Transport.(*oauth2.Transport)
+	require.True(t, ok)
+	base, ok := transport.Base.(*http.Transport)
+	require.True(t, ok)
+	require.NotNil(t, base.TLSClientConfig.ClientSessionCache)
+
+	for i := 0; i < 2; i++ {
+		result, err := client.Get(ctx, server.URL, "")
+		require.NoError(t, err)
+		require.Equal(t, "Bearer token-1", result)
+	}
+	require.Len(t, src.tokens, 1)
+
+	src.tokens[0].Expiry = time.Now().Add(-time.Minute)
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token-2", result)
+	require.Len(t, src.tokens, 2)
+}
+
+func TestSignHMAC(t *testing.T) {
+	ctx := context.TODO()
+	sigRegexp := regexp.MustCompile(`^keyId=partner,headers=host;content-type,signature=([0-9a-f]{64})$`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matches := sigRegexp.FindStringSubmatch(r.Header.Get("X-Signature"))
+		require.NotNil(t, matches, r.Header.Get("X-Signature"))
+
+		timestamp := r.Header.Get("X-Timestamp")
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		require.NoError(t, err)
+		require.InDelta(t, time.Now().Unix(), unix, 5)
+
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		sum := sha256.Sum256(body)
+		stringToSign := r.Method + "\n" + r.URL.RequestURI() + "\n" + timestamp + "\n" +
+			"host:" + r.Host + "\n" + "content-type:" + r.Header.Get("Content-Type") + "\n" + hex.EncodeToString(sum[:])
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(stringToSign))
+		if !hmac.Equal([]byte(matches[1]), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, string(body))
+	}))
+	defer server.Close()
+
+	client := New()
+
+	// the signer placed before the body is set still signs the final body
+	result, err := client.Post(ctx, server.URL+"/orders?id=1", "", SignHMAC("partner", "secret", []string{"Host", "Content-Type"}),
+		SetFormData(url.Values{"amount": {"100"}}))
+	require.NoError(t, err)
+	require.Equal(t, "amount=100", result)
+
+	_, err = client.Post(ctx, server.URL, "", SignHMAC("partner", "wrong", []string{"Host", "Content-Type"}))
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusUnauthorized, httpErr.StatusCode)
+
+	ch := make(chan []byte)
+	close(ch)
+	_, err = client.Post(ctx, server.URL, "", SignHMAC("partner", "secret", nil), BodyFromChannel(ch))
+	require.True(t, errors.Is(err, ErrUnsignableBody))
+}
+
+// stubResolver resolves every host to the addrs, counting the lookups
+type stubResolver struct {
+	addrs   []string
+	lookups int32
+}
+
+// LookupHost implements the hostResolver interface
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	return r.addrs, nil
+}
+
+func TestDNSCache(t *testing.T) {
+	ctx := context.TODO()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	target := "http://api.example.test:" + serverURL.Port()
+
+	resolver := &stubResolver{addrs: []string{serverURL.Hostname()}}
+	client := New(SetTransport(&http.Transport{DisableKeepAlives: true}), withDNSCache(resolver, 100*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Get(ctx, target, "")
+		require.NoError(t, err)
+		require.Equal(t, "api.example.test:"+serverURL.Port(), result)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&resolver.lookups))
+
+	time.Sleep(150 * time.Millisecond)
+	_, err = client.Get(ctx, target, "")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&resolver.lookups))
+}
+
+func TestWithDialer(t *testing.T) {
+	ctx := context.TODO()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	}))
+	defer server.Close()
+
+	// reserve a free local port to bind
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	localAddr := listener.Addr().(*net.TCPAddr)
+	require.NoError(t, listener.Close())
+
+	dialer := &net.Dialer{LocalAddr: localAddr, Timeout: time.Second}
+	client := New(WithDialer(dialer), WithTLSSessionCache(nil))
+	result, err := client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, localAddr.String(), result)
+	require.NotNil(t, client.Transport.(*http.Transport).TLSClientConfig.ClientSessionCache)
+
+	dials := int32(0)
+	client = New(WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}))
+	_, err = client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&dials))
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	ctx := context.TODO()
+	const n = 3
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	client := New(WithMaxConcurrency(n))
+	var wg sync.WaitGroup
+	for i := 0; i < 2*n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := client.Get(ctx, server.URL, "")
+			require.NoError(t, err)
+			require.Equal(t, "ok", result)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(n), atomic.LoadInt32(&maxInFlight))
+
+	// the waiting request gives up once its context is done
+	client = New(WithMaxConcurrency(1))
+	stream, _, err := client.GetStream(ctx, server.URL)
+	require.NoError(t, err)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Get(timeoutCtx, server.URL, "")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	require.NoError(t, stream.Close())
+	_, err = client.Get(ctx, server.URL, "")
+	require.NoError(t, err)
+
+	// n <= 0 is unlimited
+	client = New(WithMaxConcurrency(0))
+	stream, _, err = client.GetStream(ctx, server.URL)
+	require.NoError(t, err)
+	defer stream.Close()
+	timeoutCtx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err = client.Get(timeoutCtx, server.URL, "")
+	require.NoError(t, err)
+}
+
+func TestSingleFlight(t *testing.T) {
+	ctx := context.TODO()
+	var requests, canceled int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			atomic.AddInt32(&canceled, 1)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, strings.TrimSpace("shared "+r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	client := New(WithSingleFlight())
+	fire := func(path string, check func(result string, err error)) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				check(client.Get(ctx, server.URL+path, ""))
+			}()
+		}
+		// wait for the callers to join the in-flight request before releasing it
+		time.Sleep(100 * time.Millisecond)
+		release <- struct{}{}
+		wg.Wait()
+	}
+
+	fire("/", func(result string, err error) {
+		require.NoError(t, err)
+		require.Equal(t, "shared", result)
+	})
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	fire("/missing", func(result string, err error) {
+		var httpErr *HTTPError
+		require.True(t, errors.As(err, &httpErr))
+		require.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+	})
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// the callers with different credentials never share the response
+	var wg sync.WaitGroup
+	for _, token := range []string{"a", "b"} {
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				result, err := client.Get(ctx, server.URL, "", SetHeader("Authorization", token))
+				require.NoError(t, err)
+				require.Equal(t, "shared "+token, result)
+			}(token)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	release <- struct{}{}
+	release <- struct{}{}
+	wg.Wait()
+	require.Equal(t, int32(4), atomic.LoadInt32(&requests))
+
+	// the caller starting the call gives up, while the others still get the response
+	cancelCtx, cancel := context.WithCancel(ctx)
+	leaderErr := make(chan error)
+	go func() {
+		_, err := client.Get(cancelCtx, server.URL, "")
+		leaderErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := client.Get(ctx, server.URL, "")
+			require.NoError(t, err)
+			require.Equal(t, "shared", result)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	require.True(t, errors.Is(<-leaderErr, context.Canceled))
+	release <- struct{}{}
+	wg.Wait()
+	require.Equal(t, int32(5), atomic.LoadInt32(&requests))
+
+	// each caller gets its own copy of the shared response
+	responses := make([]*Response, 2)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.DoResponse(ctx, "GET", server.URL, "")
+			require.NoError(t, err)
+			responses[i] = resp
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	release <- struct{}{}
+	wg.Wait()
+	require.Equal(t, int32(6), atomic.LoadInt32(&requests))
+	require.NotSame(t, responses[0].Response, responses[1].Response)
+	responses[0].Header.Set("X-Changed", "true")
+	require.Empty(t, responses[1].Header.Get("X-Changed"))
+	require.Equal(t, "shared", responses[1].Result)
+
+	// the shared call is canceled once all the callers give up
+	cancelCtx, cancel = context.WithCancel(ctx)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Get(cancelCtx, server.URL, "")
+			require.True(t, errors.Is(err, context.Canceled))
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&canceled) == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(7), atomic.LoadInt32(&requests))
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		_, err := client.Post(ctx, server.URL, "")
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(9), atomic.LoadInt32(&requests))
+
+	// the signed request is signed only once when sent, and never shared
+	var signs int32
+	sign := func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return withSigner(ctx, func(ctx context.Context, req *http.Request) error {
+			atomic.AddInt32(&signs, 1)
+			return nil
+		}), nil
+	}
+	_, err := client.Get(ctx, server.URL, "", sign)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&signs))
+	require.Equal(t, int32(10), atomic.LoadInt32(&requests))
+}
+
+func TestGetPaginated(t *testing.T) {
+	ctx := context.TODO()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Add("Link", `<https://example.com/first>; rel="first"`)
+			w.Header().Add("Link", `</items?page=2>; rel="next last"`)
+			fmt.Fprint(w, "page 1")
+		case "2":
+			w.Header().Set("Link", `<items?page=1>; rel=prev, <items?page=3>; rel="next"`)
+			fmt.Fprint(w, "page 2")
+		case "3":
+			w.Header().Set("Link", `<items?page=2>; rel="prev"`)
+			fmt.Fprint(w, "page 3")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := New()
+	it := client.GetPaginated(ctx, server.URL+"/items")
+	var pages []string
+	for {
+		body, ok := it.Next()
+		if !ok {
+			break
+		}
+		pages = append(pages, body)
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"page 1", "page 2", "page 3"}, pages)
+
+	it = client.GetPaginated(ctx, server.URL+"/items?page=4")
+	_, ok := it.Next()
+	require.False(t, ok)
+	var httpErr *HTTPError
+	require.True(t, errors.As(it.Err(), &httpErr))
+}
+
+func TestOptionsAllowed(t *testing.T) {
+	ctx := context.TODO()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "OPTIONS", r.Method)
+		w.Header().Set("Allow", "GET, POST,PUT, ")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	methods, err := New().OptionsAllowed(ctx, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, []string{"GET", "POST", "PUT"}, methods)
+}
+
+func TestHeadHeaders(t *testing.T) {
+	ctx := context.TODO()
+	lastModified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.txt", lastModified, strings.NewReader("hello world"))
+	}))
+	defer server.Close()
+
+	client := New()
+	header, err := client.HeadHeaders(ctx, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "11", header.Get("Content-Length"))
+	require.Equal(t, lastModified.Format(http.TimeFormat), header.Get("Last-Modified"))
+
+	_, err = client.HeadHeaders(ctx, server.URL, SetHeader("Range", "bytes=100-"))
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, httpErr.StatusCode)
+}
+
+func TestHTTPErrorCategory(t *testing.T) {
+	ctx := context.TODO()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	client := New(DisableRedirect)
+	for _, c := range []struct {
+		code     int
+		category ErrorCategory
+	}{
+		{http.StatusNotFound, CategoryClientError},
+		{http.StatusServiceUnavailable, CategoryServerError},
+		{http.StatusNotModified, CategoryOther},
+	} {
+		_, err := client.Get(ctx, fmt.Sprintf("%v/%v", server.URL, c.code), "")
+		var httpErr *HTTPError
+		require.True(t, errors.As(err, &httpErr))
+		require.Equal(t, c.category, httpErr.Category())
+		require.Equal(t, c.category == CategoryClientError, httpErr.IsClientError())
+		require.Equal(t, c.category == CategoryServerError, httpErr.IsServerError())
+	}
+	require.Equal(t, "server error", CategoryServerError.String())
+}
+
+func TestHTTPErrorBody(t *testing.T) {
+	ctx := context.TODO()
+	const errBody = `{"code":"invalid_param","message":"name is required"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, strings.Repeat("x", 1024))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, errBody)
+	}))
+	defer server.Close()
+
+	client := New()
+	_, err := client.Post(ctx, server.URL, `{}`)
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, errBody, httpErr.Body)
+	require.Equal(t, errBody, httpErr.ResponseBody())
+	require.Equal(t, fmt.Sprintf("HTTP Error: 400, 400 Bad Request, body: %q", errBody), err.Error())
+
+	_, err = client.Get(ctx, server.URL+"/large", "")
+	require.True(t, errors.As(err, &httpErr))
+	require.Len(t, httpErr.ResponseBody(), 1024)
+	require.Contains(t, err.Error(), strings.Repeat("x", 512)+`..."`)
+	require.NotContains(t, err.Error(), strings.Repeat("x", 513))
+}
+
+func TestLogContextFunc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("hello") == "world" {
+			fmt.Fprintf(w, "hello world")
+		} else {
+			fmt.Fprintf(w, "bad hello")
+		}
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), DisableRedirect)
+	client.SetDefaultReqOpts(func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return log.WithContext(ctx, "log_method", req.Method), nil
+	})
+
+	query := url.Values{}
+	query.Add("hello", "world")
+
+	result, err := client.Get(ctx, server.URL, "", SetQuery(query))
+	require.NoError(t, err)
+	require.Equal(t, "hello world", result)
+}
+
+func TestResolveLocation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/next?a=1")
+		w.WriteHeader(http.StatusFound)
+	}))
+
+	ctx := context.TODO()
+	client := New(Timeout(time.Second*5), DisableRedirect)
+
+	resp, err := client.DoResponse(ctx, "GET", server.URL+"/path/prev", "")
+	require.IsType(t, &HTTPError{}, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := resp.ResolveLocation()
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/next?a=1", location.String())
 }
 
 func TestMain(m *testing.M) {
@@ -0,0 +1,66 @@
+package httpclient
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// SchemaViolation is a single JSON schema violation of the response body
+type SchemaViolation struct {
+	Path        string
+	Description string
+}
+
+// SchemaValidationError is the error returned when the response body violates the JSON schema
+type SchemaValidationError struct {
+	Violations []SchemaViolation
+}
+
+// Error implements the error interface
+func (e *SchemaValidationError) Error() string {
+	violations := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, fmt.Sprintf("%v: %v", v.Path, v.Description))
+	}
+	return fmt.Sprintf("JSON Schema Validation Error: %v", strings.Join(violations, "; "))
+}
+
+// jsonSchemaValidator validates the JSON data against the compiled schema
+type jsonSchemaValidator struct {
+	schema *gojsonschema.Schema
+	err    error
+}
+
+// newJSONSchemaValidator compiles the schema, the compile error is kept and reported on validation
+func newJSONSchemaValidator(schema []byte) *jsonSchemaValidator {
+	v := &jsonSchemaValidator{}
+	v.schema, v.err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	return v
+}
+
+// validate validates the JSON data, returns *SchemaValidationError if the data violates the schema
+func (v *jsonSchemaValidator) validate(data string) error {
+	if v.err != nil {
+		return v.err
+	}
+
+	result, err := v.schema.Validate(gojsonschema.NewStringLoader(data))
+	if err != nil {
+		return err
+	}
+
+	if result.Valid() {
+		return nil
+	}
+
+	validationErr := &SchemaValidationError{}
+	for _, e := range result.Errors() {
+		validationErr.Violations = append(validationErr.Violations, SchemaViolation{
+			Path:        e.Field(),
+			Description: e.Description(),
+		})
+	}
+	return validationErr
+}
@@ -3,10 +3,19 @@ package httpclient
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
 
 	"github.com/std0d9k81/log"
 )
 
+// JSONMarshalFunc is the function to marshal the JSON request body, json.Marshal by default
+type JSONMarshalFunc func(v interface{}) ([]byte, error)
+
+// JSONUnmarshalFunc is the function to unmarshal the JSON response body, json.Unmarshal by default
+type JSONUnmarshalFunc func(data []byte, v interface{}) error
+
 // JSONClient is an wrapper of *Client, which talks in JSON
 type JSONClient struct {
 	*Client
@@ -53,41 +62,192 @@ func (client *JSONClient) Delete(ctx context.Context, url string, body, result i
 	return client.Do(ctx, "DELETE", url, body, result, reqOpts...)
 }
 
-// Do sends a custom METHOD request
+// Do sends a custom METHOD request, and decodes the JSON response into result.
+// The result is left untouched for the 204 No Content, 205 Reset Content and 304 Not Modified responses.
 func (client *JSONClient) Do(ctx context.Context, method, url string, body, result interface{}, reqOpts ...RequestOption) error {
 	var (
-		bodyData  []byte
-		resultStr string
-		err       error
+		bodyData []byte
+		resp     *Response
+		err      error
 	)
 
-	if body != nil {
-		switch bodyValue := body.(type) {
-		case string:
-			bodyData = []byte(bodyValue)
-		case json.RawMessage:
-			bodyData = []byte(bodyValue)
-		case []byte:
-			bodyData = bodyValue
-		default:
-			if bodyData, err = json.Marshal(body); err != nil {
-				log.Error(ctx, "marshal request body", "error", err)
-				return err
-			}
-		}
+	if bodyData, err = client.marshalBody(body); err != nil {
+		log.Error(ctx, "marshal request body", "error", err)
+		return err
 	}
 
 	reqOpts = append([]RequestOption{SetTypeJSON()}, reqOpts...)
 
-	if resultStr, err = client.Client.Do(ctx, method, url, string(bodyData), reqOpts...); err != nil {
+	decoded := false
+	if client.incomplete != nil && result != nil {
+		ctx = context.WithValue(ctx, callValidatorKey{}, ResponseValidator(func(resp *Response) (decodeErr error) {
+			if decoded, decodeErr = client.decodeComplete(resp, result); decodeErr != nil || decoded {
+				return decodeErr
+			}
+			return Retriable(ErrIncompleteResponse)
+		}))
+	}
+
+	if resp, err = client.Client.DoResponse(ctx, method, url, string(bodyData), reqOpts...); err != nil {
 		return err
 	}
+	if decoded || noContent(resp) {
+		return nil
+	}
+
+	if client.contentTypeCheck {
+		if err = checkContentType(resp, isJSONType); err != nil {
+			log.Error(ctx, "check response content type", "error", err)
+			return err
+		}
+	}
+
+	if client.jsonSchema != nil && resp.Result != "" {
+		if err = client.jsonSchema.validate(resp.Result); err != nil {
+			log.Error(ctx, "validate response body", "error", err)
+			return err
+		}
+	}
 
-	if result != nil && resultStr != "" {
-		if err = json.Unmarshal([]byte(resultStr), result); err != nil {
+	if result != nil && resp.Result != "" {
+		if err = client.unmarshalJSON([]byte(resp.Result), result); err != nil {
+			err = newDecodeError(resp, err)
 			log.Error(ctx, "unmarshal response body", "error", err)
 			return err
 		}
 	}
 	return nil
 }
+
+// PostChunked posts the items slice in chunks of chunkSize items, each as its own request, e.g. for the APIs capping the
+// batch size. The response of each chunk is decoded into an interface{} and passed to perChunk if not nil.
+// It stops on the first error of the requests or perChunk.
+func (client *JSONClient) PostChunked(ctx context.Context, url string, items interface{}, chunkSize int, perChunk func(result interface{}) error, reqOpts ...RequestOption) error {
+	value := reflect.ValueOf(items)
+	if value.Kind() != reflect.Slice {
+		return fmt.Errorf("post chunks of non-slice %T", items)
+	}
+	if chunkSize < 1 {
+		return fmt.Errorf("invalid chunk size: %v", chunkSize)
+	}
+
+	for start := 0; start < value.Len(); start += chunkSize {
+		end := start + chunkSize
+		if end > value.Len() {
+			end = value.Len()
+		}
+
+		var result interface{}
+		if err := client.Do(ctx, "POST", url, value.Slice(start, end).Interface(), &result, reqOpts...); err != nil {
+			log.Error(ctx, "post chunk", "chunk_start", start, "chunk_end", end, "error", err)
+			return err
+		}
+
+		if perChunk != nil {
+			if err := perChunk(result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeStream sends a custom METHOD request, and decodes the JSON response into result by json.Decoder as it streams in,
+// instead of reading the whole body into memory first. The JSON codec and the schema validation of the client are not
+// applied, and retries are disabled since the body can't be replayed.
+func (client *JSONClient) DecodeStream(ctx context.Context, method, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	bodyData, err := client.marshalBody(body)
+	if err != nil {
+		log.Error(ctx, "marshal request body", "error", err)
+		return err
+	}
+
+	reqOpts = append([]RequestOption{SetTypeJSON()}, reqOpts...)
+
+	stream, _, err := client.Client.stream(ctx, method, url, string(bodyData), reqOpts...)
+	if err != nil {
+		return err
+	}
+	// nolint: errcheck
+	defer stream.Close()
+
+	if result == nil {
+		return nil
+	}
+	if err = json.NewDecoder(stream).Decode(result); err != nil && err != io.EOF {
+		log.Error(ctx, "decode response stream", "error", err)
+		return err
+	}
+	return nil
+}
+
+// marshalBody marshals the request body, the string, json.RawMessage and []byte are sent as is
+func (client *JSONClient) marshalBody(body interface{}) ([]byte, error) {
+	switch bodyValue := body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(bodyValue), nil
+	case json.RawMessage:
+		return []byte(bodyValue), nil
+	case []byte:
+		return bodyValue, nil
+	default:
+		return client.marshalJSON(body)
+	}
+}
+
+// decodeComplete decodes the response into a new value of the result type, and sets result by it if the value is
+// accepted by the completeness predicate. The decode error is retriable.
+func (client *JSONClient) decodeComplete(resp *Response, result interface{}) (complete bool, err error) {
+	if noContent(resp) {
+		return true, nil
+	}
+
+	if client.contentTypeCheck {
+		if err = checkContentType(resp, isJSONType); err != nil {
+			return false, err
+		}
+	}
+
+	if client.jsonSchema != nil && resp.Result != "" {
+		if err = client.jsonSchema.validate(resp.Result); err != nil {
+			return false, err
+		}
+	}
+
+	target := reflect.ValueOf(result)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return false, fmt.Errorf("decode result into non-pointer %T", result)
+	}
+
+	value := reflect.New(target.Type().Elem())
+	if resp.Result != "" {
+		if err = client.unmarshalJSON([]byte(resp.Result), value.Interface()); err != nil {
+			// the truncated body is incomplete as well
+			return false, Retriable(newDecodeError(resp, err))
+		}
+	}
+
+	if !client.incomplete(value.Interface()) {
+		return false, nil
+	}
+	target.Elem().Set(value.Elem())
+	return true, nil
+}
+
+// marshalJSON marshals v by the client JSON codec
+func (client *JSONClient) marshalJSON(v interface{}) ([]byte, error) {
+	if client.jsonMarshal != nil {
+		return client.jsonMarshal(v)
+	}
+	return json.Marshal(v)
+}
+
+// unmarshalJSON unmarshals data by the client JSON codec
+func (client *JSONClient) unmarshalJSON(data []byte, v interface{}) error {
+	if client.jsonUnmarshal != nil {
+		return client.jsonUnmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,89 @@
+package httpclient
+
+import (
+	"context"
+
+	"github.com/std0d9k81/log"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackClient is an wrapper of *Client, which talks in MessagePack
+type MsgpackClient struct {
+	*Client
+}
+
+// NewMsgpack create a MessagePack http client instance with specified options
+func NewMsgpack(opts ...ClientOption) *MsgpackClient {
+	client := New(opts...)
+	return &MsgpackClient{client}
+}
+
+// Options sends the OPTIONS request
+func (client *MsgpackClient) Options(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "OPTIONS", url, body, result, reqOpts...)
+}
+
+// Head sends the HEAD request
+func (client *MsgpackClient) Head(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "HEAD", url, body, result, reqOpts...)
+}
+
+// Get sends the GET request
+func (client *MsgpackClient) Get(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "GET", url, body, result, reqOpts...)
+}
+
+// Post sends the POST request
+func (client *MsgpackClient) Post(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "POST", url, body, result, reqOpts...)
+}
+
+// Patch sends the PATCH request
+func (client *MsgpackClient) Patch(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "PATCH", url, body, result, reqOpts...)
+}
+
+// Put sends the PUT request
+func (client *MsgpackClient) Put(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "PUT", url, body, result, reqOpts...)
+}
+
+// Delete sends the DELETE request
+func (client *MsgpackClient) Delete(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "DELETE", url, body, result, reqOpts...)
+}
+
+// Do sends a custom METHOD request, the body is sent as is if it is []byte, otherwise marshaled
+func (client *MsgpackClient) Do(ctx context.Context, method, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	var (
+		bodyData  []byte
+		resultStr string
+		err       error
+	)
+
+	if body != nil {
+		switch bodyValue := body.(type) {
+		case []byte:
+			bodyData = bodyValue
+		default:
+			if bodyData, err = msgpack.Marshal(body); err != nil {
+				log.Error(ctx, "marshal request body", "error", err)
+				return err
+			}
+		}
+	}
+
+	reqOpts = append([]RequestOption{SetTypeMsgpack()}, reqOpts...)
+
+	if resultStr, err = client.Client.Do(ctx, method, url, string(bodyData), reqOpts...); err != nil {
+		return err
+	}
+
+	if result != nil && resultStr != "" {
+		if err = msgpack.Unmarshal([]byte(resultStr), result); err != nil {
+			log.Error(ctx, "unmarshal response body", "error", err)
+			return err
+		}
+	}
+	return nil
+}
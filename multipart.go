@@ -0,0 +1,167 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// multipartSegment is the segment of the multipart body, either the data in memory or the file content streamed from path
+type multipartSegment struct {
+	data []byte
+	path string
+	size int64
+}
+
+// multipartBody is the `multipart/form-data` body, whose files are streamed from the disk instead of loaded into memory.
+// The progress of the file contents sent is reported if set.
+type multipartBody struct {
+	contentType string
+	segments    []multipartSegment
+	size        int64
+	fileSize    int64
+	progress    ProgressFunc
+}
+
+// sortedKeys returns the keys of m in order, so that the parts are written deterministically
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newMultipartBody lays out the multipart body of the form fields and the files keyed by the field name,
+// the size of the body is known by the file sizes without reading the files
+func newMultipartBody(fields, files map[string]string) (*multipartBody, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	body := &multipartBody{contentType: writer.FormDataContentType()}
+
+	for _, name := range sortedKeys(fields) {
+		if err := writer.WriteField(name, fields[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedKeys(files) {
+		path := files[name]
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("multipart file %v is not a regular file", path)
+		}
+
+		if _, err = writer.CreateFormFile(name, filepath.Base(path)); err != nil {
+			return nil, err
+		}
+		body.segments = append(body.segments,
+			multipartSegment{data: append([]byte(nil), buf.Bytes()...)},
+			multipartSegment{path: path, size: info.Size()})
+		body.size += int64(buf.Len()) + info.Size()
+		body.fileSize += info.Size()
+		buf.Reset()
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	body.segments = append(body.segments, multipartSegment{data: buf.Bytes()})
+	body.size += int64(buf.Len())
+	return body, nil
+}
+
+// multipartReader reads the segments of the multipart body in order, and closes the opened files
+type multipartReader struct {
+	io.Reader
+	files []*os.File
+}
+
+// Close implements the io.Closer interface
+func (r *multipartReader) Close() error {
+	var err error
+	for _, file := range r.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// open opens the files, and returns the reader of the whole body
+func (body *multipartBody) open() (io.ReadCloser, error) {
+	reader := &multipartReader{}
+	readers := make([]io.Reader, 0, len(body.segments))
+	sent := int64(0)
+	for _, segment := range body.segments {
+		if segment.path == "" {
+			readers = append(readers, bytes.NewReader(segment.data))
+			continue
+		}
+
+		file, err := os.Open(segment.path)
+		if err != nil {
+			// nolint: errcheck
+			reader.Close()
+			return nil, err
+		}
+		reader.files = append(reader.files, file)
+
+		if body.progress == nil {
+			readers = append(readers, file)
+		} else {
+			readers = append(readers, &progressReader{Reader: file, written: sent, total: body.fileSize, progress: body.progress})
+			sent += segment.size
+		}
+	}
+	reader.Reader = io.MultiReader(readers...)
+	return reader, nil
+}
+
+// SetMultipart replaces the body with the `multipart/form-data` of the form fields, and the files keyed by the field name
+// whose values are the file paths. The files are streamed when the body is sent, and the Content-Length is set by the file
+// sizes, so the files should not be modified until the request is done.
+func SetMultipart(fields map[string]string, files map[string]string) RequestOption {
+	return setMultipart(fields, files, nil)
+}
+
+// setMultipart replaces the body with the `multipart/form-data`, and reports the progress of the file contents sent if set
+func setMultipart(fields map[string]string, files map[string]string, progress ProgressFunc) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		if bodylessMethods[req.Method] {
+			return ctx, fmt.Errorf("%w: %v", ErrBodyNotAllowed, req.Method)
+		}
+
+		body, err := newMultipartBody(fields, files)
+		if err != nil {
+			return ctx, err
+		}
+		body.progress = progress
+
+		if req.Body, err = body.open(); err != nil {
+			return ctx, err
+		}
+		req.GetBody = body.open
+		req.ContentLength = body.size
+		req.Header.Set("Content-Type", body.contentType)
+		return ctx, nil
+	}
+}
+
+// UploadFile uploads the file as the `multipart/form-data` field, and returns the response body.
+// The file is streamed with the Content-Length set by its size, and the progress of the file content sent is reported
+// to progress if not nil, which restarts from zero if the upload is retried.
+func (client *Client) UploadFile(ctx context.Context, method, url, fieldName, filePath string, progress ProgressFunc, reqOpts ...RequestOption) (result string, err error) {
+	reqOpts = append(reqOpts[:len(reqOpts):len(reqOpts)], setMultipart(nil, map[string]string{fieldName: filePath}, progress))
+	return client.Do(ctx, method, url, "", reqOpts...)
+}
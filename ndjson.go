@@ -0,0 +1,66 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// NDJSONStream iterates the records of the newline delimited JSON response as they arrive
+type NDJSONStream struct {
+	body      io.ReadCloser
+	reader    *bufio.Reader
+	unmarshal JSONUnmarshalFunc
+	err       error
+}
+
+// GetNDJSON sends the GET request, and returns the stream of the newline delimited JSON response for the caller to
+// iterate and close. Like GetStream, canceling ctx aborts the stream, and retries are disabled.
+func (client *Client) GetNDJSON(ctx context.Context, url string, reqOpts ...RequestOption) (*NDJSONStream, error) {
+	reqOpts = append([]RequestOption{SetHeader("Accept", "application/x-ndjson")}, reqOpts...)
+
+	body, _, err := client.stream(ctx, "GET", url, "", reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	unmarshal := client.jsonUnmarshal
+	if unmarshal == nil {
+		unmarshal = json.Unmarshal
+	}
+	return &NDJSONStream{body: body, reader: bufio.NewReader(body), unmarshal: unmarshal}, nil
+}
+
+// Next decodes the next record into v, the blank lines are skipped. It returns false at the end of the stream,
+// or on the error reported by Err.
+func (s *NDJSONStream) Next(v interface{}) bool {
+	for s.err == nil {
+		line, err := s.reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if s.err = s.unmarshal(line, v); s.err != nil {
+				return false
+			}
+			return true
+		}
+
+		if err != nil {
+			s.err = err
+		}
+	}
+	return false
+}
+
+// Err returns the error stopping the iteration, nil at the end of the stream
+func (s *NDJSONStream) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// Close closes the response body
+func (s *NDJSONStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,15 @@
+package httpclient
+
+import "golang.org/x/oauth2"
+
+// WithOAuth2 authorizes every request by the `Authorization: Bearer` token of src, wrapping the client transport.
+// The token is reused until it expires, then refreshed from src. The transport options compose with it in any order,
+// since they customize the wrapped transport, while SetTransport replaces the whole chain and should come first.
+func WithOAuth2(src oauth2.TokenSource) ClientOption {
+	return func(client *Client) {
+		client.Transport = &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, src),
+			Base:   client.Transport,
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PageIterator iterates the pages linked by the `Link: <url>; rel="next"` response header
+type PageIterator struct {
+	client  *Client
+	ctx     context.Context
+	next    string
+	reqOpts []RequestOption
+	err     error
+}
+
+// GetPaginated returns the iterator of the pages starting from url, each page is sent as the GET request by DoResponse
+// with reqOpts, and the next page is the url of the `rel="next"` link resolved against the current request url.
+func (client *Client) GetPaginated(ctx context.Context, url string, reqOpts ...RequestOption) *PageIterator {
+	return &PageIterator{
+		client:  client,
+		ctx:     ctx,
+		next:    url,
+		reqOpts: reqOpts[:len(reqOpts):len(reqOpts)],
+	}
+}
+
+// Next returns the body of the next page. It returns false once the page has no next link,
+// or on the error reported by Err.
+func (it *PageIterator) Next() (body string, ok bool) {
+	if it.next == "" || it.err != nil {
+		return "", false
+	}
+
+	resp, err := it.client.DoResponse(it.ctx, "GET", it.next, "", it.reqOpts...)
+	if err != nil {
+		it.err = err
+		return "", false
+	}
+
+	current := it.next
+	it.next = ""
+	if link := nextLink(resp.Header); link != "" {
+		ref, err := url.Parse(link)
+		if err != nil {
+			it.err = err
+			return "", false
+		}
+		if resp.Request != nil && resp.Request.URL != nil {
+			ref = resp.Request.URL.ResolveReference(ref)
+		}
+		// the page linking to itself ends the iteration instead of looping forever
+		if next := ref.String(); next != current {
+			it.next = next
+		}
+	}
+	return resp.Result, true
+}
+
+// Err returns the error stopping the iteration, nil if all the pages are iterated
+func (it *PageIterator) Err() error {
+	return it.err
+}
+
+// nextLink returns the target of the `rel="next"` link in the `Link` headers, see RFC 8288
+func nextLink(h http.Header) string {
+	for _, value := range h.Values("Link") {
+		for _, link := range splitUnquoted(value, ',') {
+			params := splitUnquoted(link, ';')
+			target := strings.TrimSpace(params[0])
+			if len(target) < 2 || target[0] != '<' || target[len(target)-1] != '>' {
+				continue
+			}
+
+			for _, param := range params[1:] {
+				name, value, found := strings.Cut(param, "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(unquote(strings.TrimSpace(value))) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
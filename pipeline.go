@@ -0,0 +1,44 @@
+package httpclient
+
+import (
+	"mime"
+	"net/http"
+)
+
+// ResponseStage is a stage of the response pipeline, which transforms the response body if Match returns true
+// on the `Content-Type` header of the response
+type ResponseStage struct {
+	Match     func(contentType string) bool
+	Transform func(resp *http.Response, data []byte) ([]byte, error)
+}
+
+// MatchContentType returns the matcher of ResponseStage, matching the media types regardless of the parameters
+func MatchContentType(mediaTypes ...string) func(contentType string) bool {
+	return func(contentType string) bool {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		for _, t := range mediaTypes {
+			if mediaType == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// runPipeline runs the matching stages of the response pipeline in order, each stage sees the `Content-Type`
+// header updated by the previous ones
+func (client *Client) runPipeline(resp *http.Response, data []byte) ([]byte, error) {
+	var err error
+	for _, stage := range client.pipeline {
+		if stage.Match != nil && !stage.Match(resp.Header.Get("Content-Type")) {
+			continue
+		}
+		if data, err = stage.Transform(resp, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,50 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+// ProblemError is the RFC 7807 problem details of the response with the `application/problem+json` content type
+type ProblemError struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+
+	// HTTPError is the http error status code info of the response
+	HTTPError *HTTPError `json:"-"`
+}
+
+// Error implements the error interface
+func (e *ProblemError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("HTTP Problem: %v, %v: %v", e.HTTPError.StatusCode, e.Title, e.Detail)
+	}
+	return fmt.Sprintf("HTTP Problem: %v, %v", e.HTTPError.StatusCode, e.Title)
+}
+
+// Unwrap returns the HTTPError, so that the ProblemError is also the *HTTPError by errors.As
+func (e *ProblemError) Unwrap() error {
+	return e.HTTPError
+}
+
+// newStatusError creates the error of the unsuccessful status code, which is *ProblemError for the problem details,
+// otherwise *HTTPError
+func newStatusError(resp *http.Response) error {
+	httpErr := newHTTPError(resp)
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/problem+json" {
+		return httpErr
+	}
+
+	problem := &ProblemError{HTTPError: httpErr}
+	if err = json.Unmarshal([]byte(httpErr.Body), problem); err != nil {
+		return httpErr
+	}
+	return problem
+}
@@ -0,0 +1,45 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// ProgressFunc is the callback of the transfer progress, total is -1 if unknown
+type ProgressFunc func(written, total int64)
+
+// progressKey is the context key of the progress callback
+type progressKey struct{}
+
+// WithProgress reports the progress of DownloadFile to fn, which is invoked on each read of the response body,
+// and invoked one last time at completion with the final count.
+func WithProgress(fn ProgressFunc) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return context.WithValue(ctx, progressKey{}, fn), nil
+	}
+}
+
+// progressFromContext returns the progress callback in context, or nil if not set
+func progressFromContext(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn
+}
+
+// progressReader is a counting reader, which reports the progress on each read
+type progressReader struct {
+	io.Reader
+	written  int64
+	total    int64
+	progress ProgressFunc
+}
+
+// Read implements the io.Reader interface
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if n > 0 {
+		r.written += int64(n)
+		r.progress(r.written, r.total)
+	}
+	return n, err
+}
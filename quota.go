@@ -0,0 +1,35 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is the error that the client has issued all the requests allowed by WithRequestQuota
+var ErrQuotaExceeded = errors.New("request quota exceeded")
+
+// requestQuota is the total number of the requests allowed to be issued
+type requestQuota struct {
+	limit int64
+	used  int64
+}
+
+// take counts the request to be sent, it fails with ErrQuotaExceeded once the quota is exhausted
+func (quota *requestQuota) take() error {
+	if quota == nil {
+		return nil
+	}
+	if atomic.AddInt64(&quota.used, 1) > quota.limit {
+		quota.giveBack()
+		return fmt.Errorf("%w: limit %v", ErrQuotaExceeded, quota.limit)
+	}
+	return nil
+}
+
+// giveBack returns the request counted by take, which is not sent
+func (quota *requestQuota) giveBack() {
+	if quota != nil {
+		atomic.AddInt64(&quota.used, -1)
+	}
+}
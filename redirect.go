@@ -0,0 +1,124 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// defaultMaxRedirects is the max redirects followed by the http.Client default policy
+const defaultMaxRedirects = 10
+
+var (
+	// ErrCrossHostRedirect is the error redirecting to a different host, when only the same host is allowed
+	ErrCrossHostRedirect = errors.New("redirect to a different host")
+	// ErrSchemeDowngrade is the error redirecting from https to http
+	ErrSchemeDowngrade = errors.New("redirect from https to http")
+)
+
+// RedirectConfig is the redirect policy
+type RedirectConfig struct {
+	// MaxRedirects is the max number of redirects followed, 0 means the default 10, negative disables redirection
+	MaxRedirects int
+	// SameHostOnly rejects the redirects to a different host
+	SameHostOnly bool
+	// RejectSchemeDowngrade rejects the redirects from https to http
+	RejectSchemeDowngrade bool
+	// PreserveHeaders are the headers of the original request kept on each redirected request,
+	// including the sensitive ones like `Authorization` that are dropped on cross-domain redirects by default
+	PreserveHeaders []string
+}
+
+// checkRedirect implements the http.Client CheckRedirect policy
+func (cfg RedirectConfig) checkRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := cfg.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	if maxRedirects < 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	prev := via[len(via)-1]
+	if cfg.SameHostOnly && req.URL.Host != via[0].URL.Host {
+		return ErrCrossHostRedirect
+	}
+	if cfg.RejectSchemeDowngrade && prev.URL.Scheme == "https" && req.URL.Scheme == "http" {
+		return ErrSchemeDowngrade
+	}
+
+	for _, key := range cfg.PreserveHeaders {
+		if values, ok := via[0].Header[http.CanonicalHeaderKey(key)]; ok {
+			req.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	return nil
+}
+
+// RedirectHop is a redirect traversed while sending the request
+type RedirectHop struct {
+	From       string
+	To         string
+	StatusCode int
+}
+
+// redirectHopsKey is the context key of the redirect hops recorder
+type redirectHopsKey struct{}
+
+// checkRedirect applies the redirect policy, the same as http.Client does if CheckRedirect is nil
+func checkRedirect(policy func(*http.Request, []*http.Request) error, req *http.Request, via []*http.Request) error {
+	if policy != nil {
+		return policy(req, via)
+	}
+	if len(via) >= defaultMaxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// traceRedirect wraps the redirect policy to record the redirect hops followed
+func traceRedirect(policy func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if err := checkRedirect(policy, req, via); err != nil {
+			return err
+		}
+
+		if hops, ok := req.Context().Value(redirectHopsKey{}).(*[]RedirectHop); ok {
+			hop := RedirectHop{
+				From: via[len(via)-1].URL.String(),
+				To:   req.URL.String(),
+			}
+			if req.Response != nil {
+				hop.StatusCode = req.Response.StatusCode
+			}
+			*hops = append(*hops, hop)
+		}
+		return nil
+	}
+}
+
+// sendTraced sends the request by the underlying http client, and delivers the redirect hops if traced
+func (client *Client) sendTraced(req *http.Request) (*http.Response, error) {
+	httpClient, err := client.httpClient(req)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+	if client.redirectTrace == nil {
+		return httpClient.Do(req)
+	}
+
+	// trace the redirects with a copy of the http client, so that the redirect policy is left untouched
+	tracedClient := *httpClient
+	tracedClient.CheckRedirect = traceRedirect(client.CheckRedirect)
+
+	hops := []RedirectHop{}
+	req = req.WithContext(context.WithValue(req.Context(), redirectHopsKey{}, &hops))
+	resp, err := tracedClient.Do(req)
+	client.redirectTrace(hops)
+	return resp, err
+}
@@ -1,11 +1,57 @@
 package httpclient
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 )
 
+var (
+	// ErrBodyNotAllowed is the error setting the body of the request whose method doesn't allow a body
+	ErrBodyNotAllowed = errors.New("request body not allowed")
+	// ErrUnresolvedPathParam is the error that a `{name}` token remains in the path after substitution
+	ErrUnresolvedPathParam = errors.New("unresolved path param")
+)
+
+// pathParamRegexp matches the `{name}` token in the path
+var pathParamRegexp = regexp.MustCompile(`\{([^{}/]*)\}`)
+
+// bodylessMethods are the http methods not allowing a request body
+var bodylessMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"TRACE":   true,
+	"CONNECT": true,
+}
+
+// setBody replaces the request body with data, the body can be sent again by GetBody
+func setBody(req *http.Request, data []byte) error {
+	if bodylessMethods[req.Method] {
+		return fmt.Errorf("%w: %v", ErrBodyNotAllowed, req.Method)
+	}
+
+	req.ContentLength = int64(len(data))
+	if len(data) == 0 {
+		req.Body = http.NoBody
+		req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return nil
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil
+}
+
 // RequestOption defines the request option to customize the request
 type RequestOption func(ctx context.Context, req *http.Request) (newctx context.Context, err error)
 
@@ -27,21 +73,240 @@ func SetTypeJSON() RequestOption {
 	return SetHeader("Content-Type", "application/json; charset=UTF-8")
 }
 
+// SetTypeYAML sets the Content-Type to `application/yaml`
+func SetTypeYAML() RequestOption {
+	return SetHeader("Content-Type", "application/yaml; charset=UTF-8")
+}
+
+// SetTypeMsgpack sets the Content-Type to `application/msgpack`
+func SetTypeMsgpack() RequestOption {
+	return SetHeader("Content-Type", "application/msgpack")
+}
+
 // SetTypeForm sets the Content-Type to `application/x-www-form-urlencoded`
 func SetTypeForm() RequestOption {
 	return SetHeader("Content-Type", "application/x-www-form-urlencoded")
 }
 
-// SetQuery sets the query params
+// Expect100Continue sets the `Expect: 100-continue` header, so that the body is sent after the server accepts the request.
+// If the server responds 417 Expectation Failed, the request is retried without the Expect header.
+func Expect100Continue() RequestOption {
+	return SetHeader("Expect", "100-continue")
+}
+
+// SetFormData sets the Content-Type to `application/x-www-form-urlencoded`, and replaces the body with the encoded form
+func SetFormData(values url.Values) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		if err := setBody(req, []byte(values.Encode())); err != nil {
+			return ctx, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return ctx, nil
+	}
+}
+
+// chanReader reads the chunks from the channel until it is closed
+type chanReader struct {
+	ch  <-chan []byte
+	buf []byte
+}
+
+// Read implements the io.Reader interface
+func (r *chanReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		chunk, ok := <-r.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		r.buf = chunk
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// BodyFromChannel streams the chunks received from ch as the request body in chunked transfer encoding,
+// until ch is closed. The body can't be sent again, so the retries are unsupported, see ContextWithoutRetry.
+func BodyFromChannel(ch <-chan []byte) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		if bodylessMethods[req.Method] {
+			return ctx, fmt.Errorf("%w: %v", ErrBodyNotAllowed, req.Method)
+		}
+
+		req.Body = ioutil.NopCloser(&chanReader{ch: ch})
+		req.GetBody = nil
+		req.ContentLength = -1
+		return ctx, nil
+	}
+}
+
+// EnsureTrailingSlash appends the trailing slash to the url path if missing, to avoid the redirect by the server.
+// The root path and the query are left untouched.
+func EnsureTrailingSlash() RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		if req.URL.Path == "" || strings.HasSuffix(req.URL.Path, "/") {
+			return ctx, nil
+		}
+
+		req.URL.Path += "/"
+		if req.URL.RawPath != "" {
+			req.URL.RawPath += "/"
+		}
+		return ctx, nil
+	}
+}
+
+// StripTrailingSlash removes the trailing slashes from the url path, to avoid the redirect by the server.
+// The root path and the query are left untouched.
+func StripTrailingSlash() RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		path := strings.TrimRight(req.URL.Path, "/")
+		if path == "" || path == req.URL.Path {
+			return ctx, nil
+		}
+
+		req.URL.Path = path
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = strings.TrimRight(req.URL.RawPath, "/")
+		}
+		return ctx, nil
+	}
+}
+
+// ForceHTTP10 sends the request in HTTP/1.0 with keep-alive disabled, e.g. for testing the server behavior under HTTP/1.0.
+// The request is sent on a new connection, and the body is buffered since HTTP/1.0 has no chunked transfer encoding.
+// The connection is dialed by the dial functions, the http or https proxy and the TLS config of the client transport,
+// and the request fails with ErrHTTP10Unsupported if the transport is not *http.Transport or wrapped by WithOAuth2.
+func ForceHTTP10() RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		req.Proto = "HTTP/1.0"
+		req.ProtoMajor = 1
+		req.ProtoMinor = 0
+		req.Close = true
+		return ctx, nil
+	}
+}
+
+// SetCookies adds the cookies to the request
+func SetCookies(cookies ...*http.Cookie) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return ctx, nil
+	}
+}
+
+// SetCookieValue adds the cookie with name and value to the request
+func SetCookieValue(name, value string) RequestOption {
+	return SetCookies(&http.Cookie{Name: name, Value: value})
+}
+
+// SetQuery appends the query params to the url query, so that the options accumulate. The existing query is kept
+// as is, and the params are appended in the sorted key order, with the values of each key in the given order.
 func SetQuery(values url.Values) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		encoded := values.Encode()
+		switch {
+		case encoded == "":
+		case req.URL.RawQuery == "":
+			req.URL.RawQuery = encoded
+		default:
+			req.URL.RawQuery += "&" + encoded
+		}
+		return ctx, nil
+	}
+}
+
+// SetQueryParam sets the query param to value, replacing the existing values of key unlike SetQuery.
+// The url query is re-encoded in the sorted key order.
+func SetQueryParam(key, value string) RequestOption {
 	return func(ctx context.Context, req *http.Request) (context.Context, error) {
 		q := req.URL.Query()
-		for k, v := range values {
-			for _, vv := range v {
-				q.Add(k, vv)
+		q.Set(key, value)
+		req.URL.RawQuery = q.Encode()
+		return ctx, nil
+	}
+}
+
+// DelQueryParam removes all the values of the query param key.
+// The url query is re-encoded in the sorted key order.
+func DelQueryParam(key string) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		q := req.URL.Query()
+		q.Del(key)
+		req.URL.RawQuery = q.Encode()
+		return ctx, nil
+	}
+}
+
+// SetPathParams replaces the `{name}` tokens in the path with the url escaped param values,
+// it returns ErrUnresolvedPathParam if any token is not resolved.
+func SetPathParams(params map[string]string) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		var (
+			path, rawPath strings.Builder
+			last          int
+		)
+
+		for _, loc := range pathParamRegexp.FindAllStringSubmatchIndex(req.URL.Path, -1) {
+			name := req.URL.Path[loc[2]:loc[3]]
+			value, ok := params[name]
+			if !ok {
+				return ctx, fmt.Errorf("%w: %v", ErrUnresolvedPathParam, name)
 			}
+
+			literal := req.URL.Path[last:loc[0]]
+			path.WriteString(literal + value)
+			rawPath.WriteString((&url.URL{Path: literal}).EscapedPath() + url.PathEscape(value))
+			last = loc[1]
 		}
-		req.URL.RawQuery = q.Encode()
+
+		if last == 0 {
+			return ctx, nil
+		}
+
+		literal := req.URL.Path[last:]
+		path.WriteString(literal)
+		rawPath.WriteString((&url.URL{Path: literal}).EscapedPath())
+		req.URL.Path = path.String()
+		req.URL.RawPath = rawPath.String()
+		return ctx, nil
+	}
+}
+
+// SetGzipBody compresses the request body by gzip, and sets the `Content-Encoding: gzip` header.
+// It should be applied after the options setting the body, and is a no-op for the empty body.
+func SetGzipBody() RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		if req.Body == nil || req.Body == http.NoBody {
+			return ctx, nil
+		}
+
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return ctx, err
+		}
+		// nolint: errcheck
+		req.Body.Close()
+
+		if len(data) == 0 {
+			return ctx, setBody(req, data)
+		}
+
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		if _, err = zw.Write(data); err != nil {
+			return ctx, err
+		}
+		if err = zw.Close(); err != nil {
+			return ctx, err
+		}
+
+		if err = setBody(req, buf.Bytes()); err != nil {
+			return ctx, err
+		}
+		req.Header.Set("Content-Encoding", "gzip")
 		return ctx, nil
 	}
 }
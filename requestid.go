@@ -0,0 +1,19 @@
+package httpclient
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// RequestIDHeader is the header carrying the request id set by WithRequestID
+const RequestIDHeader = "X-Request-ID"
+
+// newUUID generates a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	// nolint: errcheck
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,101 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrRejectedByHeader is the error that the response is rejected by the header predicate
+var ErrRejectedByHeader = errors.New("response rejected by header")
+
+// ErrIncompleteResponse is the error that the decoded response is rejected by the completeness predicate
+var ErrIncompleteResponse = errors.New("incomplete response")
+
+// Response is the http response returned by DoResponse, the body is already read into Result.
+// BytesRead is the count of the body bytes read on the wire before decoding, and BytesDecoded is the count after.
+// Both are the decoded count if the body is transparently decompressed by the transport.
+// ServerTiming is the metrics parsed from the `Server-Timing` header, and SetCookies is the cookies set by the response.
+type Response struct {
+	*http.Response
+	Result       string
+	BytesRead    int64
+	BytesDecoded int64
+	ServerTiming []ServerTimingMetric
+	SetCookies   []*http.Cookie
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+// Read implements the io.Reader interface
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// ResponseValidator validates the response with the body already read, the request fails if it returns error.
+// Mark the error by Retriable to retry the request, e.g. a 200 response indicating the job is still processing.
+type ResponseValidator func(resp *Response) error
+
+// callValidatorKey is the context key of the response validator of a single call, applied after the client validators
+type callValidatorKey struct{}
+
+// ResolveLocation resolves the `Location` header against the final request URL
+func (resp *Response) ResolveLocation() (*url.URL, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, http.ErrNoLocation
+	}
+
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Request == nil || resp.Request.URL == nil {
+		return ref, nil
+	}
+	return resp.Request.URL.ResolveReference(ref), nil
+}
+
+// noContent checks whether the response has no content to decode by its status, which is 204 No Content,
+// 205 Reset Content, or 304 Not Modified not served from the response cache
+func noContent(resp *Response) bool {
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusResetContent:
+		return true
+	case http.StatusNotModified:
+		return resp.Result == ""
+	}
+	return false
+}
+
+// isJSONType checks whether the media type is JSON, e.g. `application/json` or `application/problem+json`
+func isJSONType(mediaType string) bool {
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isXMLType checks whether the media type is XML, e.g. `application/xml` or `application/atom+xml`
+func isXMLType(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// checkContentType checks the Content-Type of the non-empty response by match, it fails with *UnexpectedContentTypeError
+// if the Content-Type is missing or not matched
+func checkContentType(resp *Response, match func(mediaType string) bool) error {
+	if resp.Result == "" {
+		return nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && match(mediaType) {
+		return nil
+	}
+	return newUnexpectedContentTypeError(resp)
+}
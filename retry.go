@@ -0,0 +1,151 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+// SleepFunc sleeps for d between the retry attempts, it should return the context error once ctx is done
+type SleepFunc func(ctx context.Context, d time.Duration) error
+
+// sleepContext sleeps for d, it returns the context error once ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryKey is the context key of the per-call retry backoff strategy
+type retryKey struct{}
+
+// ContextWithRetry overrides the client retry policy by the backoff for the calls with the returned context.
+// The retry classifier of the client is kept, or DefaultRetryClassifier is used if the client has none.
+func ContextWithRetry(ctx context.Context, backoff []time.Duration) context.Context {
+	return context.WithValue(ctx, retryKey{}, BackoffSchedule(backoff[:len(backoff):len(backoff)]))
+}
+
+// ContextWithoutRetry disables the client retry policy for the calls with the returned context
+func ContextWithoutRetry(ctx context.Context) context.Context {
+	return ContextWithRetry(ctx, nil)
+}
+
+// IdempotencyKeyHeader is the header marking the request idempotent, which is allowed to be retried by any method
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// AttemptKeyHeader is the header of the per-attempt key set by SetIdempotencyKey
+const AttemptKeyHeader = "X-Attempt-Key"
+
+// attemptKey is the context key of the attempt number of the request, starting from 1
+type attemptKey struct{}
+
+// attemptFromContext returns the attempt number of the request, 1 if not retried
+func attemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// SetIdempotencyKey sets the `Idempotency-Key` header to key constant across the retries, and the `X-Attempt-Key`
+// header to `<key>-<attempt>` varying per attempt, so that the server dedups the retries while logging each attempt.
+// A random key is generated if key is empty, so the option should be created per call then.
+func SetIdempotencyKey(key string) RequestOption {
+	if key == "" {
+		key = newUUID()
+	}
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		req.Header.Set(IdempotencyKeyHeader, key)
+		req.Header.Set(AttemptKeyHeader, fmt.Sprintf("%v-%d", key, attemptFromContext(ctx)))
+		return ctx, nil
+	}
+}
+
+// retryGateKey is the context key of the flag telling whether the request is allowed to be retried
+type retryGateKey struct{}
+
+// retryAllowed checks whether the request is allowed to be retried by its method, or the `Idempotency-Key` header
+func (client *Client) retryAllowed(req *http.Request) bool {
+	methods := idempotentMethods
+	if client.retryMethods != nil {
+		methods = client.retryMethods
+	}
+	return methods[req.Method] || req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// gatedClassifier classifies the error as failed if the request is not allowed to be retried
+type gatedClassifier struct {
+	retrier.Classifier
+	allowed *bool
+}
+
+// Classify implements the retrier.Classifier interface
+func (c gatedClassifier) Classify(err error) retrier.Action {
+	action := c.Classifier.Classify(err)
+	if action == retrier.Retry && !*c.allowed {
+		return retrier.Fail
+	}
+	return action
+}
+
+// retryFromContext returns the per-call retry backoff strategy if overridden
+func retryFromContext(ctx context.Context) (backoff BackoffStrategy, ok bool) {
+	backoff, ok = ctx.Value(retryKey{}).(BackoffStrategy)
+	return backoff, ok
+}
+
+// retry runs work until it succeeds, fails, the backoff strategy stops, or the next attempt would start after
+// the max elapsed time, sleeping the backoff between attempts.
+// It returns the context error once ctx is done after a failed attempt, without waiting for the backoff sleep,
+// while the attempt succeeded before ctx is done is returned as is.
+func (client *Client) retry(ctx context.Context, backoff BackoffStrategy, classifier retrier.Classifier, work func() error) error {
+	sleep := client.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	begin := time.Now()
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := work()
+		if err == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		switch classifier.Classify(err) {
+		case retrier.Succeed, retrier.Fail:
+			return err
+		}
+
+		d, ok := backoff.NextBackoff(attempt, err)
+		if !ok {
+			return err
+		}
+		if client.maxRetryElapsed > 0 && time.Since(begin)+d >= client.maxRetryElapsed {
+			return err
+		}
+
+		for _, fn := range client.onRetry {
+			fn(attempt, err, d)
+		}
+
+		if err = sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
@@ -1,17 +1,79 @@
 package httpclient
 
 import (
+	"context"
+	"errors"
 	"net"
-	"strings"
+	"syscall"
 
 	"github.com/eapache/go-resiliency/retrier"
 )
 
-// HTTP2RetriableError defines the errors that considered retriable
+// HTTP2RetriableError defines the HTTP/2 error codes that considered retriable
 var HTTP2RetriableError = []string{
 	"CONNECT_ERROR",
 	"PROTOCOL_ERROR",
 	"STREAM_CLOSED",
+	"REFUSED_STREAM",
+}
+
+// retriableError is the error marked as retriable
+type retriableError struct {
+	err error
+}
+
+// Error implements the error interface
+func (e *retriableError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the marked error
+func (e *retriableError) Unwrap() error {
+	return e.err
+}
+
+// Retriable marks the error as retriable by the RetryClassifier
+func Retriable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retriableError{err}
+}
+
+// transientErrnos are the connection errors considered transient
+var transientErrnos = []error{
+	syscall.ECONNRESET,
+	syscall.ECONNREFUSED,
+	syscall.ECONNABORTED,
+	syscall.EPIPE,
+}
+
+// isTransientNetError checks whether the error is a transient network failure, e.g. timeout, connection reset or refused,
+// the temporary DNS failure, or the failure dialing the connection before anything is sent.
+// The canceled context is not transient.
+func isTransientNetError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
 }
 
 // DefaultRetryClassifier is the default retry classifier
@@ -26,16 +88,69 @@ func (r *RetryClassifier) Classify(err error) retrier.Action {
 		return retrier.Succeed
 	}
 
-	if ne, ok := err.(net.Error); ok && ne.Temporary() {
+	var re *retriableError
+	if errors.As(err, &re) {
 		return retrier.Retry
 	}
 
-	errContent := err.Error()
-	for _, http2RetriableError := range HTTP2RetriableError {
-		if strings.Contains(errContent, http2RetriableError) {
-			return retrier.Retry
+	if isTransientNetError(err) {
+		return retrier.Retry
+	}
+
+	if h2Err := asHTTP2StreamError(err); h2Err != nil {
+		for _, code := range HTTP2RetriableError {
+			if h2Err.Code == code {
+				return retrier.Retry
+			}
 		}
 	}
 
 	return retrier.Fail
 }
+
+// anyClassifier retries if any of the classifiers retries
+type anyClassifier []retrier.Classifier
+
+// AnyClassifier combines the classifiers, which retries if any of them retries, e.g. the DefaultRetryClassifier
+// extended by a custom one. The nil error always succeeds.
+func AnyClassifier(classifiers ...retrier.Classifier) retrier.Classifier {
+	return anyClassifier(classifiers)
+}
+
+// Classify implements the retrier.Classifier interface
+func (c anyClassifier) Classify(err error) retrier.Action {
+	if err == nil {
+		return retrier.Succeed
+	}
+	for _, classifier := range c {
+		if classifier.Classify(err) == retrier.Retry {
+			return retrier.Retry
+		}
+	}
+	return retrier.Fail
+}
+
+// allClassifier retries only if all of the classifiers retry
+type allClassifier []retrier.Classifier
+
+// AllClassifier combines the classifiers, which retries only if all of them retry, and fails without any classifier.
+// The nil error always succeeds.
+func AllClassifier(classifiers ...retrier.Classifier) retrier.Classifier {
+	return allClassifier(classifiers)
+}
+
+// Classify implements the retrier.Classifier interface
+func (c allClassifier) Classify(err error) retrier.Action {
+	if err == nil {
+		return retrier.Succeed
+	}
+	if len(c) == 0 {
+		return retrier.Fail
+	}
+	for _, classifier := range c {
+		if classifier.Classify(err) != retrier.Retry {
+			return retrier.Fail
+		}
+	}
+	return retrier.Retry
+}
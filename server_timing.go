@@ -0,0 +1,87 @@
+package httpclient
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerTimingMetric is the metric of the `Server-Timing` header, see https://www.w3.org/TR/server-timing/
+type ServerTimingMetric struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// splitUnquoted splits s by sep outside the quoted strings
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns the content of the quoted string with the escapes removed, or s as is if not quoted
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ParseServerTiming parses the metrics of the `Server-Timing` headers, e.g. `db;dur=53, app;dur=47.2;desc="render"`.
+// The duration is in milliseconds, and the malformed params are ignored.
+func ParseServerTiming(h http.Header) []ServerTimingMetric {
+	var metrics []ServerTimingMetric
+	for _, value := range h.Values("Server-Timing") {
+		for _, entry := range splitUnquoted(value, ',') {
+			params := splitUnquoted(entry, ';')
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+
+			metric := ServerTimingMetric{Name: name}
+			for _, param := range params[1:] {
+				kv := strings.SplitN(param, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+
+				key, val := strings.ToLower(strings.TrimSpace(kv[0])), unquote(strings.TrimSpace(kv[1]))
+				switch key {
+				case "dur":
+					if ms, err := strconv.ParseFloat(val, 64); err == nil {
+						metric.Duration = time.Duration(ms * float64(time.Millisecond))
+					}
+				case "desc":
+					metric.Description = val
+				}
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
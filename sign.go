@@ -0,0 +1,154 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// ErrUnsignableBody is the error that the request body can't be read again to be signed
+var ErrUnsignableBody = errors.New("request body can't be signed")
+
+// signerKey is the context key of the request signers
+type signerKey struct{}
+
+// requestSigner signs the final request
+type requestSigner func(ctx context.Context, req *http.Request) error
+
+// withSigner adds the signer to ctx, which runs after all the request options are applied,
+// since the signature depends on the final url, headers and body
+func withSigner(ctx context.Context, signer requestSigner) context.Context {
+	signers, _ := ctx.Value(signerKey{}).([]requestSigner)
+	return context.WithValue(ctx, signerKey{}, append(signers[:len(signers):len(signers)], signer))
+}
+
+// signRequest runs the signers in ctx in the order of the request options adding them
+func signRequest(ctx context.Context, req *http.Request) error {
+	signers, _ := ctx.Value(signerKey{}).([]requestSigner)
+	for _, signer := range signers {
+		if err := signer(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotBody returns the request body to be signed, which is read by GetBody without consuming the body.
+// ok is false if the body can't be read again, e.g. the body streamed by BodyFromChannel.
+func snapshotBody(req *http.Request) (data []byte, ok bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.GetBody == nil {
+		return nil, false, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false, err
+	}
+	// nolint: errcheck
+	defer body.Close()
+
+	if data, err = ioutil.ReadAll(body); err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// unsignedPayload is the payload hash of the AWS SigV4 signature for the body which can't be read again
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// SignAWSV4 signs the request by the AWS Signature Version 4, which sets the `Authorization`, `X-Amz-Date`
+// and `X-Amz-Content-Sha256` headers, and `X-Amz-Security-Token` for the temporary credentials.
+// The request is signed after all the request options are applied regardless of its position, so that the final
+// url, headers and body are signed, but the headers set by the WithOnRequest hooks are not. The body which can't be
+// read again, e.g. by BodyFromChannel, is signed as the `UNSIGNED-PAYLOAD` accepted by S3.
+func SignAWSV4(creds aws.Credentials, region, service string) RequestOption {
+	signer := v4.NewSigner()
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return withSigner(ctx, func(ctx context.Context, req *http.Request) error {
+			payloadHash := unsignedPayload
+			data, ok, err := snapshotBody(req)
+			if err != nil {
+				return err
+			}
+			if ok {
+				sum := sha256.Sum256(data)
+				payloadHash = hex.EncodeToString(sum[:])
+			}
+
+			req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+			return signer.SignHTTP(ctx, creds, req, payloadHash, service, region, time.Now().UTC())
+		}), nil
+	}
+}
+
+// hmacStringToSign returns the string signed by SignHMAC, which is the lines of the method, the request uri,
+// the timestamp, the `name:value` of each header to sign with the name lowercased, followed by the hex SHA-256 of the body
+func hmacStringToSign(req *http.Request, timestamp string, headersToSign []string, body []byte) string {
+	var buf bytes.Buffer
+	buf.WriteString(req.Method + "\n")
+	buf.WriteString(req.URL.RequestURI() + "\n")
+	buf.WriteString(timestamp + "\n")
+	for _, name := range headersToSign {
+		value := req.Header.Get(name)
+		if strings.EqualFold(name, "Host") {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		buf.WriteString(strings.ToLower(name) + ":" + strings.TrimSpace(value) + "\n")
+	}
+	sum := sha256.Sum256(body)
+	buf.WriteString(hex.EncodeToString(sum[:]))
+	return buf.String()
+}
+
+// SignHMAC signs the request by the HMAC-SHA256 of secret over the lines of the method, the request uri, the timestamp,
+// the `name:value` of each header in headersToSign with the name lowercased, followed by the hex SHA-256 of the body,
+// and sets the `X-Timestamp` header of the unix seconds and the `X-Signature` header in the form of
+// `keyId=<keyID>,headers=<name;name>,signature=<hex>`.
+// The request is signed after all the request options are applied regardless of its position, so that the body set
+// by any option is signed, but the headers set by the WithOnRequest hooks are not. The signing fails with
+// ErrUnsignableBody if the body can't be read again, e.g. by BodyFromChannel.
+func SignHMAC(keyID, secret string, headersToSign []string) RequestOption {
+	headersToSign = headersToSign[:len(headersToSign):len(headersToSign)]
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return withSigner(ctx, func(ctx context.Context, req *http.Request) error {
+			data, ok, err := snapshotBody(req)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrUnsignableBody
+			}
+
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(hmacStringToSign(req, timestamp, headersToSign, data)))
+
+			names := make([]string, 0, len(headersToSign))
+			for _, name := range headersToSign {
+				names = append(names, strings.ToLower(name))
+			}
+			req.Header.Set("X-Timestamp", timestamp)
+			req.Header.Set("X-Signature", fmt.Sprintf("keyId=%s,headers=%s,signature=%s",
+				keyID, strings.Join(names, ";"), hex.EncodeToString(mac.Sum(nil))))
+			return nil
+		}), nil
+	}
+}
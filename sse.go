@@ -0,0 +1,205 @@
+package httpclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/std0d9k81/log"
+)
+
+// ErrEventStreamClosed is the error that the server closes the event stream by the `204 No Content` response
+var ErrEventStreamClosed = errors.New("event stream closed by server")
+
+// DefaultSSERetry is the reconnection interval of the event stream, until the server sets it by the `retry:` field
+const DefaultSSERetry = 3 * time.Second
+
+// Event is the event of the Server-Sent Events stream, the Event type is `message` unless set by the server
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// EventStream is the Server-Sent Events stream subscribed by Subscribe, which reconnects on disconnect
+type EventStream struct {
+	client      *Client
+	url         string
+	reqOpts     []RequestOption
+	events      chan Event
+	cancel      context.CancelFunc
+	done        chan struct{}
+	retry       time.Duration
+	lastEventID string
+	err         error
+}
+
+// Subscribe sends the GET request to the Server-Sent Events endpoint, and delivers the events by Events.
+// The stream reconnects on disconnect after the retry interval set by the server, DefaultSSERetry by default,
+// with the `Last-Event-ID` header to resume. The client Timeout limits each connection, after which it reconnects.
+// The stream stops once ctx is done, Close is called, or the server responds an error status or `204 No Content`, see Err.
+func (client *Client) Subscribe(ctx context.Context, url string, reqOpts ...RequestOption) (*EventStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &EventStream{
+		client:  client,
+		url:     url,
+		reqOpts: reqOpts[:len(reqOpts):len(reqOpts)],
+		events:  make(chan Event),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		retry:   DefaultSSERetry,
+	}
+
+	body, err := s.connect(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go s.run(ctx, body)
+	return s, nil
+}
+
+// Events returns the channel of the events, which is closed once the stream stops
+func (s *EventStream) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns the error stopping the stream, it is valid after the events channel is closed
+func (s *EventStream) Err() error {
+	return s.err
+}
+
+// Close stops the stream, and waits for the connection to be closed
+func (s *EventStream) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// connect opens the event stream, resuming from the last event id if any
+func (s *EventStream) connect(ctx context.Context) (io.ReadCloser, error) {
+	reqOpts := append([]RequestOption{SetHeader("Accept", "text/event-stream"), SetHeader("Cache-Control", "no-cache")}, s.reqOpts...)
+	if s.lastEventID != "" {
+		reqOpts = append(reqOpts, SetHeader("Last-Event-ID", s.lastEventID))
+	}
+
+	body, resp, err := s.client.stream(ctx, "GET", s.url, "", reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		// nolint: errcheck
+		body.Close()
+		return nil, ErrEventStreamClosed
+	}
+	return body, nil
+}
+
+// run reads the events, and reconnects on disconnect until ctx is done or the server refuses the stream
+func (s *EventStream) run(ctx context.Context, body io.ReadCloser) {
+	defer close(s.done)
+	defer close(s.events)
+
+	sleep := s.client.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	for {
+		err := s.read(ctx, body)
+		// nolint: errcheck
+		body.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Debug(ctx, "event stream disconnected", "url", s.url, "error", err)
+
+		for {
+			if sleep(ctx, s.retry) != nil {
+				return
+			}
+
+			if body, err = s.connect(ctx); err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			var httpErr *HTTPError
+			if errors.Is(err, ErrEventStreamClosed) || errors.As(err, &httpErr) {
+				s.err = err
+				return
+			}
+			log.Debug(ctx, "event stream reconnect", "url", s.url, "error", err)
+		}
+	}
+}
+
+// read parses the event stream, and delivers the events until the stream ends, see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+func (s *EventStream) read(ctx context.Context, body io.Reader) error {
+	var (
+		reader    = bufio.NewReader(body)
+		eventType string
+		data      strings.Builder
+	)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// the incomplete event is discarded
+			return err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			if data.Len() > 0 {
+				event := Event{ID: s.lastEventID, Event: eventType, Data: strings.TrimSuffix(data.String(), "\n")}
+				if event.Event == "" {
+					event.Event = "message"
+				}
+
+				select {
+				case s.events <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			eventType = ""
+			data.Reset()
+			continue
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				s.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
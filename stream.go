@@ -0,0 +1,141 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/std0d9k81/log"
+)
+
+// ErrReadTimeout is the error that a read of the response stream exceeds the timeout set by SetReadTimeout
+var ErrReadTimeout = errors.New("response read timeout")
+
+// readTimeoutKey is the context key of the per-read timeout
+type readTimeoutKey struct{}
+
+// SetReadTimeout limits each read of the GetStream response body to d, the timer is reset on each read.
+// The stalled read aborts the request and fails with ErrReadTimeout, while a slow but steady stream is not limited,
+// except by the client Timeout, which still limits the whole request.
+func SetReadTimeout(d time.Duration) RequestOption {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return context.WithValue(ctx, readTimeoutKey{}, d), nil
+	}
+}
+
+// timeoutReader aborts the request by cancel once a read exceeds the timeout
+type timeoutReader struct {
+	io.Reader
+	timeout  time.Duration
+	cancel   context.CancelFunc
+	timedOut int32
+}
+
+// Read implements the io.Reader interface
+func (r *timeoutReader) Read(p []byte) (n int, err error) {
+	timer := time.AfterFunc(r.timeout, func() {
+		atomic.StoreInt32(&r.timedOut, 1)
+		r.cancel()
+	})
+	n, err = r.Reader.Read(p)
+	timer.Stop()
+
+	if err != nil && atomic.LoadInt32(&r.timedOut) == 1 {
+		err = fmt.Errorf("%w: %v", ErrReadTimeout, r.timeout)
+	}
+	return n, err
+}
+
+// streamBody is the decoded response body, closing both the decoder and the response body
+type streamBody struct {
+	io.ReadCloser
+	body   io.Closer
+	cancel context.CancelFunc
+}
+
+// Close implements the io.Closer interface
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	if bodyErr := b.body.Close(); err == nil {
+		err = bodyErr
+	}
+	if b.cancel != nil {
+		b.cancel()
+	}
+	return err
+}
+
+// GetStream sends the GET request, and returns the decoded response body stream for the caller to read and close.
+// The request is bound to ctx, so that canceling ctx aborts the stream, and the client Timeout also limits the
+// time to read the stream. Retries are disabled for streaming, since the body can't be replayed.
+func (client *Client) GetStream(ctx context.Context, url string, reqOpts ...RequestOption) (stream io.ReadCloser, resp *http.Response, err error) {
+	return client.stream(ctx, "GET", url, "", reqOpts...)
+}
+
+// stream is the streaming variant of do, which returns the decoded response body stream instead of reading it
+func (client *Client) stream(ctx context.Context, method, url, body string, reqOpts ...RequestOption) (stream io.ReadCloser, resp *http.Response, err error) {
+	var req *http.Request
+
+	if req, err = http.NewRequest(method, url, strings.NewReader(body)); err != nil {
+		return nil, nil, err
+	}
+
+	if ctx, err = client.prepareRequest(ctx, req, reqOpts); err != nil {
+		return nil, nil, err
+	}
+
+	readTimeout, _ := ctx.Value(readTimeoutKey{}).(time.Duration)
+	var cancel context.CancelFunc
+	if readTimeout > 0 {
+		ctx, cancel = context.WithCancel(ctx)
+		defer func() {
+			if err != nil {
+				cancel()
+			}
+		}()
+	}
+	req = req.WithContext(ctx)
+
+	ctx = log.WithContext(ctx,
+		"method", method,
+		"url", req.URL.String(),
+	)
+	ctx = client.withLogKeys(ctx)
+
+	begin := time.Now()
+	resp, err = client.send(req)
+	if err != nil {
+		log.Error(ctx, "do http request", "error", err, "proc_time", time.Since(begin))
+		return nil, nil, err
+	}
+
+	if !client.isSuccess(resp.StatusCode) {
+		err = newHTTPError(resp)
+		// nolint: errcheck
+		resp.Body.Close()
+		log.Error(ctx, "bad http status code", "error", err, "proc_time", time.Since(begin))
+		return nil, resp, err
+	}
+
+	var respBody io.Reader = resp.Body
+	if readTimeout > 0 {
+		respBody = &timeoutReader{Reader: resp.Body, timeout: readTimeout, cancel: cancel}
+	}
+
+	var reader io.ReadCloser
+	if reader, err = decodeBody(respBody, resp.Header.Get("Content-Encoding")); err != nil {
+		// nolint: errcheck
+		resp.Body.Close()
+		log.Error(ctx, "create decode reader", "error", err, "proc_time", time.Since(begin))
+		return nil, resp, err
+	}
+
+	log.Debug(ctx, "stream opened", "proc_time", time.Since(begin))
+
+	return &streamBody{ReadCloser: reader, body: resp.Body, cancel: cancel}, resp, nil
+}
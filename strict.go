@@ -0,0 +1,67 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrConflictingOptions is the error that the client options override each other in the strict mode
+var ErrConflictingOptions = errors.New("conflicting client options")
+
+// StrictOptions makes NewWithError fail with ErrConflictingOptions if the mutually exclusive client options are set,
+// e.g. SetTransport after the options customizing the transport, two redirect policies, or WithHTTP2 with WithForceHTTP1,
+// instead of the later one silently overriding the earlier one. The options customizing the transport, e.g. WithDNSCache,
+// also conflict with the transport set by SetTransport before them which is not *http.Transport, since they are ignored.
+// The conflicts are detected regardless of its position.
+func StrictOptions() ClientOption {
+	return func(client *Client) {
+		client.strictOptions = true
+	}
+}
+
+// conflict records that option overrides the setting of the earlier one
+func (client *Client) conflict(option, overridden string) {
+	client.addConflict(fmt.Sprintf("%v overrides %v", option, overridden))
+}
+
+// addConflict records the conflict, the conflicts shared with the cloned clients are left untouched
+func (client *Client) addConflict(conflict string) {
+	client.optConflicts = append(client.optConflicts[:len(client.optConflicts):len(client.optConflicts)], conflict)
+}
+
+// transportFor returns the copy of the client transport to be customized by option, see transport.
+// It records that option is ignored if the transport set by SetTransport can't be customized.
+func (client *Client) transportFor(option string) *http.Transport {
+	transport := client.transport()
+	if transport == nil {
+		client.addConflict(fmt.Sprintf("%v is ignored by the transport set by SetTransport", option))
+	}
+	return transport
+}
+
+// conflictErr returns the error of the conflicting options in the strict mode
+func (client *Client) conflictErr() error {
+	if !client.strictOptions || len(client.optConflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrConflictingOptions, strings.Join(client.optConflicts, "; "))
+}
+
+// setCheckRedirect sets the redirect policy by option, which conflicts with the policy set by another option
+func (client *Client) setCheckRedirect(option string, fn func(req *http.Request, via []*http.Request) error) {
+	if client.redirectOption != "" {
+		client.conflict(option, client.redirectOption)
+	}
+	client.redirectOption = option
+	client.CheckRedirect = fn
+}
+
+// setHTTPVersion records the HTTP version forced by option, which conflicts with the version forced by another option
+func (client *Client) setHTTPVersion(option string) {
+	if client.httpVersionOption != "" && client.httpVersionOption != option {
+		client.conflict(option, client.httpVersionOption)
+	}
+	client.httpVersionOption = option
+}
@@ -0,0 +1,114 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrNoTestResponse is the error that no test response is set for the request
+var ErrNoTestResponse = errors.New("no test response")
+
+// TestResponse is the canned response returned instead of sending the request, status code 200 is used if zero
+type TestResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+// RecordedRequest is the request answered by the test response
+type RecordedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   string
+}
+
+// testResponses is the canned responses keyed by method and url, and the requests recorded
+type testResponses struct {
+	sync.Mutex
+	responses map[string]TestResponse
+	recorded  []RecordedRequest
+}
+
+// testResponseKey returns the key of the test response
+func testResponseKey(method, url string) string {
+	return method + " " + url
+}
+
+// SetTestResponses short-circuits the requests by the canned responses keyed by `METHOD url`, e.g. `GET http://host/path?a=1`,
+// where the url is the final one after the request options applied. The requests without the test response fail with
+// ErrNoTestResponse. The responses are still checked and decoded like the real ones. It is a testing aid, and the
+// requests are sent normally if responses is nil.
+func (client *Client) SetTestResponses(responses map[string]TestResponse) {
+	if responses == nil {
+		client.testResponses = nil
+		return
+	}
+	client.testResponses = &testResponses{responses: responses}
+}
+
+// RecordedRequests returns the requests answered by the test responses
+func (client *Client) RecordedRequests() []RecordedRequest {
+	if client.testResponses == nil {
+		return nil
+	}
+
+	client.testResponses.Lock()
+	defer client.testResponses.Unlock()
+	return append([]RecordedRequest(nil), client.testResponses.recorded...)
+}
+
+// respond records the request and returns its test response
+func (stubs *testResponses) respond(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		// nolint: errcheck
+		req.Body.Close()
+	}
+
+	key := testResponseKey(req.Method, req.URL.String())
+
+	stubs.Lock()
+	defer stubs.Unlock()
+
+	stubs.recorded = append(stubs.recorded, RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+		Body:   string(body),
+	})
+
+	stub, ok := stubs.responses[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoTestResponse, key)
+	}
+
+	statusCode := stub.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	header := stub.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(stub.Body)),
+		ContentLength: int64(len(stub.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,146 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/oauth2"
+)
+
+// DialContextFunc is the function dialing the connection
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// transport returns a copy of the client transport to be customized, which is installed to the client,
+// so that the transport shared with the cloned clients is left untouched.
+// It returns nil if the client transport is not *http.Transport, or the OAuth2 transport wrapping it.
+func (client *Client) transport() *http.Transport {
+	transport, installed := customizable(client.Transport)
+	if transport != nil {
+		client.Transport = installed
+	}
+	return transport
+}
+
+// customizable returns a copy of the transport to be customized, and the round tripper installing the copy,
+// which unwraps the OAuth2 transport so that the transport options apply to the wrapped transport
+func customizable(rt http.RoundTripper) (*http.Transport, http.RoundTripper) {
+	switch t := rt.(type) {
+	case nil:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		return transport, transport
+	case *http.Transport:
+		transport := t.Clone()
+		return transport, transport
+	case *oauth2.Transport:
+		transport, base := customizable(t.Base)
+		if transport == nil {
+			return nil, nil
+		}
+		return transport, &oauth2.Transport{Source: t.Source, Base: base}
+	}
+	return nil, nil
+}
+
+// tlsConfig returns a copy of the TLS config of the transport to be customized, which is installed to the transport,
+// so that the TLS options are merged with each other.
+func tlsConfig(transport *http.Transport) *tls.Config {
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	} else {
+		transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+	}
+	return transport.TLSClientConfig
+}
+
+// dialContext returns the dial function of the transport, or the default dialer if not set
+func dialContext(transport *http.Transport) DialContextFunc {
+	if transport.DialContext != nil {
+		return transport.DialContext
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return dialer.DialContext
+}
+
+// errConnExpired is the error writing the request to the connection exceeding its max lifetime
+var errConnExpired = errors.New("connection exceeds its max lifetime")
+
+// expiringConn is the connection expiring after its max lifetime, which is retired by the next request
+// picking it from the idle pool, see traceConnLifetime
+type expiringConn struct {
+	net.Conn
+	expired int32
+	retired int32
+	timer   *time.Timer
+}
+
+// newExpiringConn creates the connection which expires after lifetime
+func newExpiringConn(conn net.Conn, lifetime time.Duration) *expiringConn {
+	c := &expiringConn{Conn: conn}
+	c.timer = time.AfterFunc(lifetime, func() {
+		atomic.StoreInt32(&c.expired, 1)
+	})
+	return c
+}
+
+// retireIfExpired makes the connection refuse the writes of the request about to start on it if it has expired.
+// Since nothing is written, the transport retries the request on a new connection, and the request in progress
+// on the connection is never interrupted.
+func (c *expiringConn) retireIfExpired() {
+	if atomic.LoadInt32(&c.expired) == 1 {
+		atomic.StoreInt32(&c.retired, 1)
+	}
+}
+
+// Write implements the net.Conn interface
+func (c *expiringConn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.retired) == 1 {
+		return 0, errConnExpired
+	}
+	return c.Conn.Write(p)
+}
+
+// Close implements the net.Conn interface
+func (c *expiringConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// traceConnLifetime retires the expired connection reused by the request, if the request can be sent again.
+// The HTTP/2 connections are shared by the concurrent requests, so they are not retired.
+func (client *Client) traceConnLifetime(req *http.Request) *http.Request {
+	if !client.connLifetime {
+		return req
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return req
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				return
+			}
+			conn := info.Conn
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+					return
+				}
+				conn = tlsConn.NetConn()
+			}
+			if c, ok := conn.(*expiringConn); ok {
+				c.retireIfExpired()
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
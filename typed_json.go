@@ -0,0 +1,26 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// GetJSON sends the GET request, and decodes the JSON response into a new Resp
+func GetJSON[Resp any](ctx context.Context, client *Client, url string, reqOpts ...RequestOption) (Resp, error) {
+	var result Resp
+	err := client.NewJSON().Do(ctx, "GET", url, nil, &result, reqOpts...)
+	return result, err
+}
+
+// PostJSON sends the POST request with the JSON encoded body, and decodes the JSON response into a new Resp.
+// The body is always marshaled, e.g. the string body is sent as the JSON string.
+func PostJSON[Req any, Resp any](ctx context.Context, client *Client, url string, body Req, reqOpts ...RequestOption) (Resp, error) {
+	var result Resp
+	jsonClient := client.NewJSON()
+	data, err := jsonClient.marshalJSON(body)
+	if err != nil {
+		return result, err
+	}
+	err = jsonClient.Do(ctx, "POST", url, json.RawMessage(data), &result, reqOpts...)
+	return result, err
+}
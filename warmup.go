@@ -0,0 +1,38 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/std0d9k81/log"
+)
+
+// warmup opens n connections to the url concurrently by the HEAD requests, which are returned to the idle pool
+func (client *Client) warmup(url string, n int) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+			if err != nil {
+				log.Error(ctx, "warm up connection", "url", url, "error", err)
+				return
+			}
+
+			resp, err := client.Client.Do(req)
+			if err != nil {
+				log.Error(ctx, "warm up connection", "url", url, "error", err)
+				return
+			}
+			// nolint: errcheck
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+}
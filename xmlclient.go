@@ -3,8 +3,10 @@ package httpclient
 import (
 	"context"
 	"encoding/xml"
+	"io"
 
 	"github.com/std0d9k81/log"
+	"golang.org/x/net/html/charset"
 )
 
 // XMLClient is an wrapper of *Client, which talks in XML
@@ -53,39 +55,97 @@ func (client *XMLClient) Delete(ctx context.Context, url string, body, result in
 	return client.Do(ctx, "DELETE", url, body, result, reqOpts...)
 }
 
-// Do sends a custom METHOD request
+// Do sends a custom METHOD request, and decodes the XML response into result.
+// The result is left untouched for the 204 No Content, 205 Reset Content and 304 Not Modified responses.
 func (client *XMLClient) Do(ctx context.Context, method, url string, body, result interface{}, reqOpts ...RequestOption) error {
 	var (
-		bodyData  []byte
-		resultStr string
-		err       error
+		bodyData []byte
+		resp     *Response
+		err      error
 	)
 
-	if body != nil {
-		switch bodyValue := body.(type) {
-		case string:
-			bodyData = []byte(bodyValue)
-		case []byte:
-			bodyData = bodyValue
-		default:
-			if bodyData, err = xml.Marshal(body); err != nil {
-				log.Error(ctx, "marshal request body", "error", err)
-				return err
-			}
-		}
+	if bodyData, err = marshalXMLBody(body); err != nil {
+		log.Error(ctx, "marshal request body", "error", err)
+		return err
 	}
 
 	reqOpts = append([]RequestOption{SetTypeXML()}, reqOpts...)
 
-	if resultStr, err = client.Client.Do(ctx, method, url, string(bodyData), reqOpts...); err != nil {
+	if resp, err = client.Client.DoResponse(ctx, method, url, string(bodyData), reqOpts...); err != nil {
 		return err
 	}
 
-	if result != nil && resultStr != "" {
-		if err = xml.Unmarshal([]byte(resultStr), result); err != nil {
+	if noContent(resp) {
+		return nil
+	}
+
+	if client.contentTypeCheck {
+		if err = checkContentType(resp, isXMLType); err != nil {
+			log.Error(ctx, "check response content type", "error", err)
+			return err
+		}
+	}
+
+	if result != nil && resp.Result != "" {
+		if err = xml.Unmarshal([]byte(resp.Result), result); err != nil {
+			err = newDecodeError(resp, err)
 			log.Error(ctx, "unmarshal response body", "error", err)
 			return err
 		}
 	}
 	return nil
 }
+
+// DoStream sends a custom METHOD request, and parses the XML response incrementally by xml.Decoder as it streams in,
+// e.g. the huge sitemaps or feeds. onElement is invoked on each start element, which should consume the element by
+// dec.DecodeElement or dec.Skip, otherwise its child elements are visited next. The non UTF-8 charsets declared by the
+// XML are transcoded, and retries are disabled since the body can't be replayed.
+func (client *XMLClient) DoStream(ctx context.Context, method, url string, body interface{}, onElement func(dec *xml.Decoder, start xml.StartElement) error, reqOpts ...RequestOption) error {
+	bodyData, err := marshalXMLBody(body)
+	if err != nil {
+		log.Error(ctx, "marshal request body", "error", err)
+		return err
+	}
+
+	reqOpts = append([]RequestOption{SetTypeXML()}, reqOpts...)
+
+	stream, _, err := client.Client.stream(ctx, method, url, string(bodyData), reqOpts...)
+	if err != nil {
+		return err
+	}
+	// nolint: errcheck
+	defer stream.Close()
+
+	dec := xml.NewDecoder(stream)
+	dec.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Error(ctx, "decode response stream", "error", err)
+			return err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			if err = onElement(dec, start); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// marshalXMLBody marshals the request body, the string and []byte are sent as is
+func marshalXMLBody(body interface{}) ([]byte, error) {
+	switch bodyValue := body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(bodyValue), nil
+	case []byte:
+		return bodyValue, nil
+	default:
+		return xml.Marshal(body)
+	}
+}
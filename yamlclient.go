@@ -0,0 +1,92 @@
+package httpclient
+
+import (
+	"context"
+
+	"github.com/std0d9k81/log"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLClient is an wrapper of *Client, which talks in YAML
+type YAMLClient struct {
+	*Client
+}
+
+// NewYAML create a YAML http client instance with specified options
+func NewYAML(opts ...ClientOption) *YAMLClient {
+	client := New(opts...)
+	return &YAMLClient{client}
+}
+
+// Options sends the OPTIONS request
+func (client *YAMLClient) Options(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "OPTIONS", url, body, result, reqOpts...)
+}
+
+// Head sends the HEAD request
+func (client *YAMLClient) Head(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "HEAD", url, body, result, reqOpts...)
+}
+
+// Get sends the GET request
+func (client *YAMLClient) Get(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "GET", url, body, result, reqOpts...)
+}
+
+// Post sends the POST request
+func (client *YAMLClient) Post(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "POST", url, body, result, reqOpts...)
+}
+
+// Patch sends the PATCH request
+func (client *YAMLClient) Patch(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "PATCH", url, body, result, reqOpts...)
+}
+
+// Put sends the PUT request
+func (client *YAMLClient) Put(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "PUT", url, body, result, reqOpts...)
+}
+
+// Delete sends the DELETE request
+func (client *YAMLClient) Delete(ctx context.Context, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	return client.Do(ctx, "DELETE", url, body, result, reqOpts...)
+}
+
+// Do sends a custom METHOD request
+func (client *YAMLClient) Do(ctx context.Context, method, url string, body, result interface{}, reqOpts ...RequestOption) error {
+	var (
+		bodyData []byte
+		resp     *Response
+		err      error
+	)
+
+	if body != nil {
+		switch bodyValue := body.(type) {
+		case string:
+			bodyData = []byte(bodyValue)
+		case []byte:
+			bodyData = bodyValue
+		default:
+			if bodyData, err = yaml.Marshal(body); err != nil {
+				log.Error(ctx, "marshal request body", "error", err)
+				return err
+			}
+		}
+	}
+
+	reqOpts = append([]RequestOption{SetTypeYAML()}, reqOpts...)
+
+	if resp, err = client.Client.DoResponse(ctx, method, url, string(bodyData), reqOpts...); err != nil {
+		return err
+	}
+
+	if result != nil && resp.Result != "" {
+		if err = yaml.Unmarshal([]byte(resp.Result), result); err != nil {
+			err = newDecodeError(resp, err)
+			log.Error(ctx, "unmarshal response body", "error", err)
+			return err
+		}
+	}
+	return nil
+}